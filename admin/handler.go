@@ -0,0 +1,183 @@
+// Package admin provides an http.Handler for inspecting and controlling
+// sessions of a sockit.ConnManager at runtime.
+//
+// Routes, relative to where the handler is mounted:
+//
+//	GET  /sessions              list all sessions
+//	GET  /sessions/{id}         show a session with its data
+//	POST /sessions/{id}/kick    close a session
+//	POST /users/{userId}/kick   close all sessions of a user, form value "reason" is optional
+//
+// The handler has no authentication, it should not be exposed publicly:
+//
+//	http.Handle("/admin/", http.StripPrefix("/admin", admin.NewHandler(mgr)))
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chenqinghe/sockit"
+)
+
+// SessionInfo is the summary of a session.
+type SessionInfo struct {
+	Id              int64     `json:"id"`
+	UserId          string    `json:"userId,omitempty"`
+	RemoteAddr      string    `json:"remoteAddr"`
+	ConnectTime     time.Time `json:"connectTime"`
+	LastPacketTime  time.Time `json:"lastPacketTime"`
+	BytesReceived   int64     `json:"bytesReceived"`
+	BytesSent       int64     `json:"bytesSent"`
+	PendingRequests int       `json:"pendingRequests"`
+}
+
+// SessionDetail is a session with its user defined data.
+type SessionDetail struct {
+	SessionInfo
+	Data map[string]interface{} `json:"data"`
+}
+
+type handler struct {
+	mgr sockit.ConnManager
+}
+
+// NewHandler returns an admin http.Handler of mgr.
+func NewHandler(mgr sockit.ConnManager) http.Handler {
+	return &handler{mgr: mgr}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "sessions" && r.Method == http.MethodGet:
+		h.listSessions(w, r)
+	case len(parts) == 2 && parts[0] == "sessions" && r.Method == http.MethodGet:
+		h.showSession(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "sessions" && parts[2] == "kick" && r.Method == http.MethodPost:
+		h.kickSession(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "kick" && r.Method == http.MethodPost:
+		h.kickUser(w, r, parts[1])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *handler) listSessions(w http.ResponseWriter, r *http.Request) {
+	infos := make([]SessionInfo, 0)
+	h.mgr.RangeSession(func(s *sockit.Session) {
+		infos = append(infos, sessionInfo(s))
+	})
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Id < infos[j].Id })
+
+	writeJson(w, http.StatusOK, infos)
+}
+
+func (h *handler) showSession(w http.ResponseWriter, r *http.Request, id string) {
+	s, ok := h.findSession(w, id)
+	if !ok {
+		return
+	}
+
+	data := s.Data()
+	for k, v := range data {
+		// values which can not be marshaled are shown in text form
+		if _, err := json.Marshal(v); err != nil {
+			data[k] = fmt.Sprintf("%+v", v)
+		}
+	}
+
+	writeJson(w, http.StatusOK, SessionDetail{
+		SessionInfo: sessionInfo(s),
+		Data:        data,
+	})
+}
+
+func (h *handler) kickSession(w http.ResponseWriter, r *http.Request, id string) {
+	s, ok := h.findSession(w, id)
+	if !ok {
+		return
+	}
+
+	if err := h.mgr.RemoveSession(s.Id()); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJson(w, http.StatusOK, map[string]int{"kicked": 1})
+}
+
+func (h *handler) kickUser(w http.ResponseWriter, r *http.Request, userId string) {
+	if um, ok := h.mgr.(sockit.UserConnManager); ok {
+		sessions, _ := um.FindSessionByUser(userId)
+		if len(sessions) > 0 {
+			if err := um.KickUser(userId, r.FormValue("reason")); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		writeJson(w, http.StatusOK, map[string]int{"kicked": len(sessions)})
+		return
+	}
+
+	var sessions []*sockit.Session
+	h.mgr.RangeSession(func(s *sockit.Session) {
+		if s.User() != nil && s.User().Id() == userId {
+			sessions = append(sessions, s)
+		}
+	})
+
+	for _, s := range sessions {
+		if err := h.mgr.RemoveSession(s.Id()); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	writeJson(w, http.StatusOK, map[string]int{"kicked": len(sessions)})
+}
+
+func (h *handler) findSession(w http.ResponseWriter, id string) (*sockit.Session, bool) {
+	sid, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid session id")
+		return nil, false
+	}
+
+	s, ok := h.mgr.FindSession(sid)
+	if !ok {
+		writeError(w, http.StatusNotFound, "session not found")
+		return nil, false
+	}
+	return s, true
+}
+
+func sessionInfo(s *sockit.Session) SessionInfo {
+	info := SessionInfo{
+		Id:              s.Id(),
+		RemoteAddr:      s.RemoteAddr().String(),
+		ConnectTime:     s.CreatedAt(),
+		LastPacketTime:  s.LastPacketTime(),
+		BytesReceived:   s.BytesReceived(),
+		BytesSent:       s.BytesSent(),
+		PendingRequests: s.PendingRequests(),
+	}
+	if s.User() != nil {
+		info.UserId = s.User().Id()
+	}
+	return info
+}
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJson(w, code, map[string]string{"error": msg})
+}
@@ -0,0 +1,243 @@
+package sockit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Backplane connects managers of several nodes, so that packets can be sent to
+// users and groups whose sessions are owned by other nodes.
+type Backplane interface {
+	// Join registers the node, messages sent to the node are passed to fn.
+	Join(node string, fn func(m BackplaneMessage)) error
+
+	// Leave unregisters the node and removes all presence of it.
+	Leave(node string) error
+
+	// Online publishes that the user has sessions on the node.
+	Online(node string, userId string) error
+
+	// Offline publishes that the user has no session on the node anymore.
+	Offline(node string, userId string) error
+
+	// Locate returns all nodes which own sessions of the user.
+	Locate(userId string) ([]string, error)
+
+	// Send delivers the message to the node.
+	Send(node string, m BackplaneMessage) error
+
+	// Broadcast delivers the message to all nodes except m.From.
+	Broadcast(m BackplaneMessage) error
+}
+
+type BackplaneMessageKind int8
+
+const (
+	// BackplaneToUser message carries a packet for sessions of user Target.
+	BackplaneToUser BackplaneMessageKind = iota + 1
+
+	// BackplaneToGroup message carries a packet for sessions in group Target.
+	BackplaneToGroup
+)
+
+// BackplaneMessage is routed between nodes by Backplane.
+type BackplaneMessage struct {
+	Kind BackplaneMessageKind
+
+	// From is the node sending the message.
+	From string
+
+	// Target is the user id or the group name.
+	Target string
+
+	// Data is the packet encoded by BackplaneCodec.
+	Data []byte
+}
+
+func defaultNodeId() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+}
+
+// NodeId returns id of the manager in Backplane.
+func (m *Manager) NodeId() string {
+	return m.node
+}
+
+func (m *Manager) joinBackplane() {
+	if m.opts.Backplane == nil {
+		return
+	}
+	if m.opts.BackplaneCodec == nil {
+		m.log.Error("backplane codec not specified, backplane disabled")
+		return
+	}
+
+	if err := m.opts.Backplane.Join(m.node, m.handleBackplane); err != nil {
+		m.log.Error("join backplane error", "node", m.node, "error", err.Error())
+		return
+	}
+	m.bp = m.opts.Backplane
+	m.bpTasks = newBackplaneTasks()
+	go m.bpTasks.run()
+}
+
+// leaveBackplane leaves after all tasks queued done.
+func (m *Manager) leaveBackplane() {
+	if m.bp == nil {
+		return
+	}
+	m.bpTasks.push(func() {
+		if err := m.bp.Leave(m.node); err != nil {
+			m.log.Error("leave backplane error", "node", m.node, "error", err.Error())
+		}
+	})
+	m.bpTasks.stop()
+}
+
+// publishPresence publishes the user online or offline on this node asynchronously,
+// it's called with ulock held, so that presence changes of a user are published in order.
+func (m *Manager) publishPresence(userId string, online bool) {
+	m.bpTasks.push(func() {
+		if online {
+			if err := m.bp.Online(m.node, userId); err != nil {
+				m.log.Error("publish user online error", "userId", userId, "error", err.Error())
+			}
+		} else {
+			if err := m.bp.Offline(m.node, userId); err != nil {
+				m.log.Error("publish user offline error", "userId", userId, "error", err.Error())
+			}
+		}
+	})
+}
+
+// backplaneTasks runs backplane operations in order in a goroutine,
+// so that a slow backplane doesn't block sessions.
+type backplaneTasks struct {
+	mu      *sync.Mutex
+	tasks   []func()
+	stopped bool
+	signal  chan struct{}
+}
+
+func newBackplaneTasks() *backplaneTasks {
+	return &backplaneTasks{
+		mu:     &sync.Mutex{},
+		signal: make(chan struct{}, 1),
+	}
+}
+
+func (t *backplaneTasks) push(fn func()) {
+	t.mu.Lock()
+	if !t.stopped {
+		t.tasks = append(t.tasks, fn)
+	}
+	t.mu.Unlock()
+
+	select {
+	case t.signal <- struct{}{}:
+	default:
+	}
+}
+
+// stop stops the goroutine after tasks queued done.
+func (t *backplaneTasks) stop() {
+	t.mu.Lock()
+	t.stopped = true
+	t.mu.Unlock()
+
+	select {
+	case t.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (t *backplaneTasks) run() {
+	for range t.signal {
+		for {
+			t.mu.Lock()
+			if len(t.tasks) == 0 {
+				stopped := t.stopped
+				t.mu.Unlock()
+				if stopped {
+					return
+				}
+				break
+			}
+			fn := t.tasks[0]
+			t.tasks[0] = nil
+			t.tasks = t.tasks[1:]
+			t.mu.Unlock()
+
+			fn()
+		}
+	}
+}
+
+func (m *Manager) handleBackplane(msg BackplaneMessage) {
+	if msg.From == m.node {
+		return
+	}
+
+	p, err := m.opts.BackplaneCodec.Read(bytes.NewReader(msg.Data))
+	if err != nil {
+		m.log.Error("decode backplane packet error", "from", msg.From, "error", err.Error())
+		return
+	}
+
+	switch msg.Kind {
+	case BackplaneToUser:
+		if _, err := m.sendToLocalUser(msg.Target, p); err != nil {
+			m.log.Error("send packet to user error", "userId", msg.Target, "from", msg.From, "error", err.Error())
+		}
+	case BackplaneToGroup:
+		m.broadcastLocal(msg.Target, p, nil)
+	default:
+		m.log.Warn("unknown backplane message", "kind", msg.Kind, "from", msg.From)
+	}
+}
+
+func (m *Manager) encodeBackplanePacket(p Packet) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := m.opts.BackplaneCodec.Write(buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// routeToUser sends the encoded packet to other nodes owning sessions of the user.
+// It reports whether there is any, and whether the packet is sent to any of them.
+func (m *Manager) routeToUser(id string, data []byte) (found bool, sent bool, err error) {
+	nodes, err := m.bp.Locate(id)
+	if err != nil {
+		return false, false, err
+	}
+
+	var firstErr error
+	for _, node := range nodes {
+		if node == m.node {
+			continue
+		}
+		found = true
+		err := m.bp.Send(node, BackplaneMessage{Kind: BackplaneToUser, From: m.node, Target: id, Data: data})
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		sent = true
+	}
+	return found, sent, firstErr
+}
+
+func (m *Manager) routeToGroup(group string, p Packet) error {
+	data, err := m.encodeBackplanePacket(p)
+	if err != nil {
+		return err
+	}
+	return m.bp.Broadcast(BackplaneMessage{Kind: BackplaneToGroup, From: m.node, Target: group, Data: data})
+}
@@ -0,0 +1,264 @@
+package backplane
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenqinghe/sockit"
+	"github.com/chenqinghe/sockit/codec"
+	"github.com/chenqinghe/sockit/reconnectpolicy"
+)
+
+// userConn is a Conn of a user, packets sent to it are passed to sent.
+type userConn struct {
+	user string
+	sent chan sockit.Packet
+
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+func newUserConn(user string) *userConn {
+	return &userConn{
+		user:      user,
+		sent:      make(chan sockit.Packet, 8),
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+}
+
+var fakeAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+func (c *userConn) LocalAddr() net.Addr  { return fakeAddr }
+func (c *userConn) RemoteAddr() net.Addr { return fakeAddr }
+
+func (c *userConn) ReadPacket() (sockit.Packet, error) {
+	<-c.closed
+	return nil, net.ErrClosed
+}
+
+func (c *userConn) SendPacket(p sockit.Packet) error {
+	c.sent <- p
+	return nil
+}
+
+func (c *userConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+// recv returns data of the packet sent to c.
+func (c *userConn) recv(t *testing.T) string {
+	t.Helper()
+	select {
+	case p := <-c.sent:
+		return string(p.(codec.TLVPacket).Data)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting packet to user %s", c.user)
+		return ""
+	}
+}
+
+type user string
+
+func (u user) Valid() bool { return true }
+func (u user) Id() string  { return string(u) }
+
+type userAuth struct{}
+
+func (userAuth) Auth(c sockit.Conn) (sockit.User, error) {
+	return user(c.(*userConn).user), nil
+}
+
+func newNode(t *testing.T, bp sockit.Backplane, node string) *sockit.Manager {
+	t.Helper()
+	mgr := sockit.NewManager(sockit.HandlerFunc(func(sockit.Packet, *sockit.Session) {}), &sockit.NewManagerOptions{
+		Authenticator:  userAuth{},
+		Backplane:      bp,
+		BackplaneCodec: codec.TLVCodec{},
+		NodeId:         node,
+		Logger:         sockit.NopLogger{},
+	})
+	t.Cleanup(func() { mgr.Close() })
+	return mgr
+}
+
+// userPacket returns a packet carrying data to users.
+func userPacket(data string) codec.TLVPacket {
+	return codec.TLVPacket{
+		PacketHead: codec.PacketHead{Type: 1, Length: uint64(len(data))},
+		Data:       []byte(data),
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitLocated waits until the user is located on nodes.
+func waitLocated(t *testing.T, bp sockit.Backplane, userId string, nodes ...string) {
+	t.Helper()
+	sort.Strings(nodes)
+	waitFor(t, "presence of "+userId, func() bool {
+		located, err := bp.Locate(userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(located)
+		if len(located) != len(nodes) {
+			return false
+		}
+		for i := range nodes {
+			if located[i] != nodes[i] {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// testRouting tests routing between managers a and b, whose backplanes are bpa and bpb.
+func testRouting(t *testing.T, bpa, bpb sockit.Backplane) {
+	a, b := newNode(t, bpa, "a"), newNode(t, bpb, "b")
+
+	alice := newUserConn("alice")
+	sa, err := a.StoreConn(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob := newUserConn("bob")
+	sb, err := b.StoreConn(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitLocated(t, bpb, "alice", "a")
+	waitLocated(t, bpa, "bob", "b")
+
+	if err := b.SendToUser("alice", userPacket("to alice")); err != nil {
+		t.Fatal(err)
+	}
+	if got := alice.recv(t); got != "to alice" {
+		t.Fatalf("alice received %q", got)
+	}
+	if err := a.SendToUser("bob", userPacket("to bob")); err != nil {
+		t.Fatal(err)
+	}
+	if got := bob.recv(t); got != "to bob" {
+		t.Fatalf("bob received %q", got)
+	}
+
+	if err := a.Join(sa.Id(), "room"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Join(sb.Id(), "room"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Broadcast("room", userPacket("hello room")); err != nil {
+		t.Fatal(err)
+	}
+	if got := alice.recv(t); got != "hello room" {
+		t.Fatalf("alice received %q", got)
+	}
+	if got := bob.recv(t); got != "hello room" {
+		t.Fatalf("bob received %q", got)
+	}
+
+	// presence is removed when the last session of the user closed
+	if err := a.RemoveSession(sa.Id()); err != nil {
+		t.Fatal(err)
+	}
+	waitLocated(t, bpb, "alice")
+	if err := b.SendToUser("alice", userPacket("to alice")); err != sockit.ErrUserNotFound {
+		t.Fatalf("err = %v, want ErrUserNotFound", err)
+	}
+
+	// presence of a node is removed when it's closed
+	if _, err := a.StoreConn(newUserConn("carol")); err != nil {
+		t.Fatal(err)
+	}
+	waitLocated(t, bpb, "carol", "a")
+	a.Close()
+	waitLocated(t, bpb, "carol")
+}
+
+func TestMemory(t *testing.T) {
+	bp := NewMemory()
+	testRouting(t, bp, bp)
+}
+
+// startHub serves a Hub on a loopback address.
+func startHub(t *testing.T) (*Hub, string) {
+	t.Helper()
+	hub := NewHub(&HubOptions{Logger: sockit.NopLogger{}})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go hub.Serve(l)
+	t.Cleanup(func() { hub.Close() })
+	return hub, l.Addr().String()
+}
+
+func dialHub(t *testing.T, addr string) *TCP {
+	t.Helper()
+	bp, err := Dial(addr, &TCPOptions{
+		ReconnectPolicy: reconnectpolicy.NewConstTime(10 * time.Millisecond),
+		Logger:          sockit.NopLogger{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bp.Close() })
+	return bp
+}
+
+func TestTCP(t *testing.T) {
+	_, addr := startHub(t)
+	testRouting(t, dialHub(t, addr), dialHub(t, addr))
+}
+
+func TestTCPRejoin(t *testing.T) {
+	hub, addr := startHub(t)
+	bpa, bpb := dialHub(t, addr), dialHub(t, addr)
+	a, b := newNode(t, bpa, "a"), newNode(t, bpb, "b")
+
+	alice := newUserConn("alice")
+	if _, err := a.StoreConn(alice); err != nil {
+		t.Fatal(err)
+	}
+	waitLocated(t, bpb, "alice", "a")
+
+	// the hub drops the connection of node a, its presence is removed
+	hub.mu.RLock()
+	sa := hub.nodes["a"]
+	hub.mu.RUnlock()
+	if err := hub.mgr.RemoveSession(sa.Id()); err != nil {
+		t.Fatal(err)
+	}
+
+	// node a joins and publishes presence again after reconnected
+	waitFor(t, "node a rejoined", func() bool {
+		hub.mu.RLock()
+		defer hub.mu.RUnlock()
+		s, ok := hub.nodes["a"]
+		return ok && s != sa
+	})
+	waitLocated(t, bpb, "alice", "a")
+
+	if err := b.SendToUser("alice", userPacket("to alice")); err != nil {
+		t.Fatal(err)
+	}
+	if got := alice.recv(t); got != "to alice" {
+		t.Fatalf("alice received %q", got)
+	}
+}
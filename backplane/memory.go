@@ -0,0 +1,167 @@
+// Package backplane implements sockit.Backplane for routing packets between managers of several nodes.
+package backplane
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/chenqinghe/sockit"
+)
+
+var ErrNodeNotFound = errors.New("node not found")
+
+// Memory is an in-process Backplane, it's useful for running several managers in one process and testing.
+type Memory struct {
+	mu       *sync.RWMutex
+	nodes    map[string]func(m sockit.BackplaneMessage)
+	presence *presence
+}
+
+var _ sockit.Backplane = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{
+		mu:       &sync.RWMutex{},
+		nodes:    make(map[string]func(m sockit.BackplaneMessage)),
+		presence: newPresence(),
+	}
+}
+
+func (mb *Memory) Join(node string, fn func(m sockit.BackplaneMessage)) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.nodes[node] = fn
+	return nil
+}
+
+func (mb *Memory) Leave(node string) error {
+	mb.mu.Lock()
+	delete(mb.nodes, node)
+	mb.mu.Unlock()
+
+	mb.presence.removeNode(node)
+	return nil
+}
+
+func (mb *Memory) Online(node string, userId string) error {
+	mb.presence.online(node, userId)
+	return nil
+}
+
+func (mb *Memory) Offline(node string, userId string) error {
+	mb.presence.offline(node, userId)
+	return nil
+}
+
+func (mb *Memory) Locate(userId string) ([]string, error) {
+	return mb.presence.locate(userId), nil
+}
+
+func (mb *Memory) Send(node string, m sockit.BackplaneMessage) error {
+	mb.mu.RLock()
+	fn, ok := mb.nodes[node]
+	mb.mu.RUnlock()
+
+	if !ok {
+		return ErrNodeNotFound
+	}
+	fn(m)
+	return nil
+}
+
+func (mb *Memory) Broadcast(m sockit.BackplaneMessage) error {
+	mb.mu.RLock()
+	fns := make([]func(m sockit.BackplaneMessage), 0, len(mb.nodes))
+	for node, fn := range mb.nodes {
+		if node != m.From {
+			fns = append(fns, fn)
+		}
+	}
+	mb.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(m)
+	}
+	return nil
+}
+
+// presence stores nodes owning sessions of users.
+type presence struct {
+	mu    *sync.RWMutex
+	users map[string]map[string]struct{} // user id -> nodes
+	nodes map[string]map[string]struct{} // node -> user ids
+}
+
+func newPresence() *presence {
+	return &presence{
+		mu:    &sync.RWMutex{},
+		users: make(map[string]map[string]struct{}),
+		nodes: make(map[string]map[string]struct{}),
+	}
+}
+
+func (p *presence) online(node, userId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.users[userId] == nil {
+		p.users[userId] = make(map[string]struct{})
+	}
+	p.users[userId][node] = struct{}{}
+
+	if p.nodes[node] == nil {
+		p.nodes[node] = make(map[string]struct{})
+	}
+	p.nodes[node][userId] = struct{}{}
+}
+
+func (p *presence) offline(node, userId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.offlineLocked(node, userId)
+}
+
+func (p *presence) offlineLocked(node, userId string) {
+	delete(p.users[userId], node)
+	if len(p.users[userId]) == 0 {
+		delete(p.users, userId)
+	}
+	delete(p.nodes[node], userId)
+	if len(p.nodes[node]) == 0 {
+		delete(p.nodes, node)
+	}
+}
+
+func (p *presence) removeNode(node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for userId := range p.nodes[node] {
+		p.offlineLocked(node, userId)
+	}
+}
+
+func (p *presence) locate(userId string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	nodes := make([]string, 0, len(p.users[userId]))
+	for node := range p.users[userId] {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// usersOf returns all users on the node.
+func (p *presence) usersOf(node string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	users := make([]string, 0, len(p.nodes[node]))
+	for userId := range p.nodes[node] {
+		users = append(users, userId)
+	}
+	return users
+}
@@ -0,0 +1,511 @@
+package backplane
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenqinghe/sockit"
+	"github.com/chenqinghe/sockit/codec"
+	"github.com/chenqinghe/sockit/reconnectpolicy"
+)
+
+// packet types between Hub and TCP backplane.
+const (
+	typeHeartbeat int32 = iota + 1
+	typeJoin            // request, Data: node
+	typeLeave           // Data: node
+	typeOnline          // Data: node, user id
+	typeOffline         // Data: node, user id
+	typeLocate          // request, Data: user id. response Data: nodes
+	typeSend            // Data: envelope
+	typeBroadcast       // Data: envelope without destination
+	typeDeliver         // Data: envelope
+)
+
+var errMalformed = errors.New("malformed backplane packet")
+
+// Hub is a sockit server which routes messages between nodes connected by TCP backplane.
+// All nodes of a cluster connect to the same Hub.
+//
+//	hub := backplane.NewHub(nil)
+//	go hub.ListenAndServe(":9000")
+//
+//	bp, err := backplane.Dial("hub:9000", nil)
+//	mgr := sockit.NewManager(handler, &sockit.NewManagerOptions{
+//		Backplane:      bp,
+//		BackplaneCodec: codec.TLVCodec{},
+//	})
+type Hub struct {
+	mgr *sockit.Manager
+	srv *sockit.Server
+
+	mu       *sync.RWMutex
+	nodes    map[string]*sockit.Session
+	presence *presence
+}
+
+type HubOptions struct {
+	Authenticator sockit.Authenticator
+
+	// KeepaliveTick is the duration after which a node connection without any packet
+	// received will be closed. Default is 10s.
+	KeepaliveTick time.Duration
+
+	Logger sockit.Logger
+}
+
+func NewHub(opts *HubOptions) *Hub {
+	if opts == nil {
+		opts = &HubOptions{}
+	}
+	if opts.KeepaliveTick == 0 {
+		opts.KeepaliveTick = 10 * time.Second
+	}
+
+	h := &Hub{
+		mu:       &sync.RWMutex{},
+		nodes:    make(map[string]*sockit.Session),
+		presence: newPresence(),
+	}
+	h.mgr = sockit.NewManager(sockit.HandlerFunc(h.handle), &sockit.NewManagerOptions{
+		Authenticator: opts.Authenticator,
+		KeepaliveTick: opts.KeepaliveTick,
+		// presence changes of a node must be applied in order
+		DispatchMode:       sockit.DispatchSerial,
+		Correlator:         codec.TLVCorrelator{},
+		Logger:             opts.Logger,
+		AfterSessionClosed: h.removeSession,
+	})
+	h.mgr.SetKeepAlive(true)
+	h.srv = sockit.NewServer(h.mgr, codec.TLVCodec{})
+	h.srv.Logger = opts.Logger
+
+	return h
+}
+
+func (h *Hub) ListenAndServe(addr string) error {
+	return h.srv.ListenAndServe(addr)
+}
+
+func (h *Hub) Serve(l net.Listener) error {
+	return h.srv.Serve(l)
+}
+
+func (h *Hub) Close() error {
+	return h.srv.Close()
+}
+
+// Nodes returns all nodes joined.
+func (h *Hub) Nodes() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	nodes := make([]string, 0, len(h.nodes))
+	for node := range h.nodes {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func (h *Hub) handle(p sockit.Packet, s *sockit.Session) {
+	pkt := p.(codec.TLVPacket)
+
+	var err error
+	switch pkt.Type {
+	case typeHeartbeat:
+	case typeJoin:
+		err = h.join(pkt, s)
+	case typeLeave:
+		err = h.leave(pkt, s)
+	case typeOnline, typeOffline:
+		err = h.setPresence(pkt)
+	case typeLocate:
+		err = h.locate(pkt, s)
+	case typeSend:
+		err = h.send(pkt)
+	case typeBroadcast:
+		err = h.broadcast(pkt)
+	default:
+		s.Logger().Warn("unknown backplane packet type", "type", pkt.Type)
+	}
+	if err != nil {
+		s.Logger().Error("handle backplane packet error", "type", pkt.Type, "error", err.Error())
+	}
+}
+
+func (h *Hub) join(pkt codec.TLVPacket, s *sockit.Session) error {
+	node, _, err := readString(pkt.Data)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.nodes[node] = s
+	h.mu.Unlock()
+
+	// the node sends all its presence again after joined
+	h.presence.removeNode(node)
+
+	return s.SendPacket(response(pkt, nil))
+}
+
+func (h *Hub) leave(pkt codec.TLVPacket, s *sockit.Session) error {
+	node, _, err := readString(pkt.Data)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	if h.nodes[node] == s {
+		delete(h.nodes, node)
+	}
+	h.mu.Unlock()
+
+	h.presence.removeNode(node)
+	return nil
+}
+
+// removeSession removes all nodes connected by the session.
+func (h *Hub) removeSession(s *sockit.Session) {
+	var nodes []string
+	h.mu.Lock()
+	for node, sess := range h.nodes {
+		if sess == s {
+			delete(h.nodes, node)
+			nodes = append(nodes, node)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, node := range nodes {
+		h.presence.removeNode(node)
+	}
+}
+
+func (h *Hub) setPresence(pkt codec.TLVPacket) error {
+	node, rest, err := readString(pkt.Data)
+	if err != nil {
+		return err
+	}
+	userId, _, err := readString(rest)
+	if err != nil {
+		return err
+	}
+
+	if pkt.Type == typeOnline {
+		h.presence.online(node, userId)
+	} else {
+		h.presence.offline(node, userId)
+	}
+	return nil
+}
+
+func (h *Hub) locate(pkt codec.TLVPacket, s *sockit.Session) error {
+	userId, _, err := readString(pkt.Data)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	for _, node := range h.presence.locate(userId) {
+		data = appendString(data, node)
+	}
+	return s.SendPacket(response(pkt, data))
+}
+
+func (h *Hub) send(pkt codec.TLVPacket) error {
+	to, _, err := decodeEnvelope(pkt.Data)
+	if err != nil {
+		return err
+	}
+
+	h.mu.RLock()
+	s, ok := h.nodes[to]
+	h.mu.RUnlock()
+	if !ok {
+		return ErrNodeNotFound
+	}
+
+	return s.SendPacketAsync(newPacket(typeDeliver, pkt.Data))
+}
+
+func (h *Hub) broadcast(pkt codec.TLVPacket) error {
+	_, m, err := decodeEnvelope(pkt.Data)
+	if err != nil {
+		return err
+	}
+
+	h.mu.RLock()
+	targets := make(map[string]*sockit.Session, len(h.nodes))
+	for node, s := range h.nodes {
+		if node != m.From {
+			targets[node] = s
+		}
+	}
+	h.mu.RUnlock()
+
+	var firstErr error
+	for node, s := range targets {
+		if err := s.SendPacketAsync(newPacket(typeDeliver, encodeEnvelope(node, m))); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// TCP is a Backplane connected to a Hub by sockit client.
+// It reconnects to the Hub automatically, and joins nodes and
+// publishes presence again after reconnected.
+type TCP struct {
+	cli  *sockit.Client
+	sess *sockit.Session
+	opts *TCPOptions
+
+	mu       *sync.RWMutex
+	nodes    map[string]func(m sockit.BackplaneMessage)
+	presence *presence
+}
+
+var _ sockit.Backplane = (*TCP)(nil)
+
+type TCPOptions struct {
+	// Timeout is the timeout of requests to Hub. Default is 5s.
+	Timeout time.Duration
+
+	// KeepalivePeriod is the duration between heartbeats. Default is 3s.
+	KeepalivePeriod time.Duration
+
+	// ReconnectPolicy specify how to reconnect to Hub. Default is reconnecting every second.
+	ReconnectPolicy sockit.ReconnectPolicy
+
+	// OnConnected is called after connection established, it's useful for authentication.
+	OnConnected func(c sockit.Conn) error
+
+	Logger sockit.Logger
+}
+
+// Dial connects to the Hub listening on addr.
+func Dial(addr string, opts *TCPOptions) (*TCP, error) {
+	if opts == nil {
+		opts = &TCPOptions{}
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.KeepalivePeriod == 0 {
+		opts.KeepalivePeriod = 3 * time.Second
+	}
+	if opts.ReconnectPolicy == nil {
+		opts.ReconnectPolicy = reconnectpolicy.NewConstTime(time.Second)
+	}
+
+	t := &TCP{
+		opts:     opts,
+		mu:       &sync.RWMutex{},
+		nodes:    make(map[string]func(m sockit.BackplaneMessage)),
+		presence: newPresence(),
+	}
+	t.cli = sockit.NewClient(codec.TLVCodec{}, sockit.HandlerFunc(t.handle), &sockit.NewClientOptions{
+		EnableKeepalive: true,
+		KeepalivePeriod: opts.KeepalivePeriod,
+		HeartbeatPacketFactory: func() sockit.Packet {
+			return newPacket(typeHeartbeat, nil)
+		},
+		OnConnected:     opts.OnConnected,
+		NeedReconnect:   true,
+		ReconnectPolicy: opts.ReconnectPolicy,
+		Correlator:      codec.TLVCorrelator{},
+		Logger:          opts.Logger,
+	})
+	t.cli.OnReconnect(t.resync)
+
+	sess, err := t.cli.Dial("tcp", addr)
+	if err != nil {
+		t.cli.Close()
+		return nil, err
+	}
+	t.sess = sess
+
+	return t, nil
+}
+
+func (t *TCP) Join(node string, fn func(m sockit.BackplaneMessage)) error {
+	t.mu.Lock()
+	t.nodes[node] = fn
+	t.mu.Unlock()
+
+	return t.join(node)
+}
+
+func (t *TCP) join(node string) error {
+	_, err := t.sess.SendRequestTimeout(newPacket(typeJoin, appendString(nil, node)), t.opts.Timeout)
+	return err
+}
+
+func (t *TCP) Leave(node string) error {
+	t.mu.Lock()
+	delete(t.nodes, node)
+	t.mu.Unlock()
+	t.presence.removeNode(node)
+
+	return t.sess.SendPacket(newPacket(typeLeave, appendString(nil, node)))
+}
+
+func (t *TCP) Online(node string, userId string) error {
+	t.presence.online(node, userId)
+	return t.sess.SendPacket(newPacket(typeOnline, appendString(appendString(nil, node), userId)))
+}
+
+func (t *TCP) Offline(node string, userId string) error {
+	t.presence.offline(node, userId)
+	return t.sess.SendPacket(newPacket(typeOffline, appendString(appendString(nil, node), userId)))
+}
+
+func (t *TCP) Locate(userId string) ([]string, error) {
+	resp, err := t.sess.SendRequestTimeout(newPacket(typeLocate, appendString(nil, userId)), t.opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []string
+	data := resp.(codec.TLVPacket).Data
+	for len(data) > 0 {
+		var node string
+		if node, data, err = readString(data); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (t *TCP) Send(node string, m sockit.BackplaneMessage) error {
+	return t.sess.SendPacketAsync(newPacket(typeSend, encodeEnvelope(node, m)))
+}
+
+func (t *TCP) Broadcast(m sockit.BackplaneMessage) error {
+	return t.sess.SendPacketAsync(newPacket(typeBroadcast, encodeEnvelope("", m)))
+}
+
+// Close closes the connection to Hub.
+func (t *TCP) Close() error {
+	return t.cli.Close()
+}
+
+func (t *TCP) handle(p sockit.Packet, s *sockit.Session) {
+	pkt := p.(codec.TLVPacket)
+	if pkt.Type != typeDeliver {
+		s.Logger().Warn("unknown backplane packet type", "type", pkt.Type)
+		return
+	}
+
+	to, m, err := decodeEnvelope(pkt.Data)
+	if err != nil {
+		s.Logger().Error("decode backplane message error", "error", err.Error())
+		return
+	}
+
+	t.mu.RLock()
+	fn, ok := t.nodes[to]
+	t.mu.RUnlock()
+	if !ok {
+		s.Logger().Warn("backplane message to unknown node", "node", to)
+		return
+	}
+	fn(m)
+}
+
+// resync joins all nodes and publishes their presence again after reconnected.
+func (t *TCP) resync(s *sockit.Session) {
+	if s != t.sess {
+		return
+	}
+
+	t.mu.RLock()
+	nodes := make([]string, 0, len(t.nodes))
+	for node := range t.nodes {
+		nodes = append(nodes, node)
+	}
+	t.mu.RUnlock()
+
+	for _, node := range nodes {
+		if err := t.join(node); err != nil {
+			s.Logger().Error("rejoin backplane error", "node", node, "error", err.Error())
+			continue
+		}
+		for _, userId := range t.presence.usersOf(node) {
+			if err := t.sess.SendPacket(newPacket(typeOnline, appendString(appendString(nil, node), userId))); err != nil {
+				s.Logger().Error("publish user online error", "node", node, "userId", userId, "error", err.Error())
+			}
+		}
+	}
+}
+
+var idGen int64
+
+func newPacket(typ int32, data []byte) codec.TLVPacket {
+	return codec.TLVPacket{
+		PacketHead: codec.PacketHead{
+			Type:   typ,
+			ID:     atomic.AddInt64(&idGen, 1),
+			Length: uint64(len(data)),
+		},
+		Data: data,
+	}
+}
+
+func response(req codec.TLVPacket, data []byte) codec.TLVPacket {
+	pkt := newPacket(req.Type|codec.TLVResponseFlag, data)
+	pkt.ID = req.ID
+	return pkt
+}
+
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errMalformed
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errMalformed
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+// encodeEnvelope encodes the message with its destination node.
+func encodeEnvelope(to string, m sockit.BackplaneMessage) []byte {
+	b := appendString(nil, to)
+	b = append(b, byte(m.Kind))
+	b = appendString(b, m.From)
+	b = appendString(b, m.Target)
+	return append(b, m.Data...)
+}
+
+func decodeEnvelope(b []byte) (string, sockit.BackplaneMessage, error) {
+	var m sockit.BackplaneMessage
+
+	to, b, err := readString(b)
+	if err != nil {
+		return "", m, err
+	}
+	if len(b) < 1 {
+		return "", m, errMalformed
+	}
+	m.Kind = sockit.BackplaneMessageKind(b[0])
+	if m.From, b, err = readString(b[1:]); err != nil {
+		return "", m, err
+	}
+	if m.Target, b, err = readString(b); err != nil {
+		return "", m, err
+	}
+	m.Data = b
+	return to, m, nil
+}
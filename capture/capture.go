@@ -0,0 +1,149 @@
+// Package capture reads and writes capture files, which record frames
+// read and written by sessions with timestamps and directions.
+//
+// A capture file starts with an 8 bytes header "SOCKCAP" followed by version,
+// then records in big endian:
+//
+//	time      int64  // unix nano
+//	sessionId int64
+//	direction uint8
+//	length    uint32
+//	frame     [length]byte
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/chenqinghe/sockit"
+)
+
+const (
+	magic   = "SOCKCAP"
+	version = 1
+
+	recordHeadSize = 8 + 8 + 1 + 4
+)
+
+var ErrInvalidFormat = errors.New("invalid capture file format")
+
+// Record is a frame read or written by a session.
+type Record struct {
+	Time      time.Time
+	SessionId int64
+	Direction sockit.Direction
+	Frame     []byte
+}
+
+// Writer writes records to capture file. It's safe for concurrent use.
+// Writer implements sockit.Tap, so it can capture sessions directly:
+//
+//	mgr.SetTap(capture.NewWriter(f))
+type Writer struct {
+	mu            *sync.Mutex
+	w             io.Writer
+	headerWritten bool
+	err           error
+}
+
+var _ sockit.Tap = (*Writer)(nil)
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		mu: &sync.Mutex{},
+		w:  w,
+	}
+}
+
+func (w *Writer) WriteRecord(r Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	if !w.headerWritten {
+		if _, w.err = w.w.Write(append([]byte(magic), version)); w.err != nil {
+			return w.err
+		}
+		w.headerWritten = true
+	}
+
+	head := make([]byte, recordHeadSize)
+	binary.BigEndian.PutUint64(head[0:], uint64(r.Time.UnixNano()))
+	binary.BigEndian.PutUint64(head[8:], uint64(r.SessionId))
+	head[16] = byte(r.Direction)
+	binary.BigEndian.PutUint32(head[17:], uint32(len(r.Frame)))
+
+	if _, w.err = w.w.Write(head); w.err != nil {
+		return w.err
+	}
+	_, w.err = w.w.Write(r.Frame)
+	return w.err
+}
+
+// Tap writes the frame of event as a record, errors can be checked by Err.
+func (w *Writer) Tap(e *sockit.TapEvent) {
+	w.WriteRecord(Record{
+		Time:      e.Time,
+		SessionId: e.SessionId,
+		Direction: e.Direction,
+		Frame:     e.Frame,
+	})
+}
+
+// Err returns the first error occurred in writing.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.err
+}
+
+// Reader reads records from capture file.
+type Reader struct {
+	r             io.Reader
+	headerChecked bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// ReadRecord reads next record, io.EOF is returned at the end of file.
+func (r *Reader) ReadRecord() (Record, error) {
+	if !r.headerChecked {
+		header := make([]byte, len(magic)+1)
+		if _, err := io.ReadFull(r.r, header); err != nil {
+			return Record{}, err
+		}
+		if string(header[:len(magic)]) != magic || header[len(magic)] != version {
+			return Record{}, ErrInvalidFormat
+		}
+		r.headerChecked = true
+	}
+
+	head := make([]byte, recordHeadSize)
+	if _, err := io.ReadFull(r.r, head); err != nil {
+		return Record{}, err
+	}
+
+	frame := make([]byte, binary.BigEndian.Uint32(head[17:]))
+	if _, err := io.ReadFull(r.r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Record{}, err
+	}
+
+	return Record{
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(head[0:]))),
+		SessionId: int64(binary.BigEndian.Uint64(head[8:])),
+		Direction: sockit.Direction(head[16]),
+		Frame:     frame,
+	}, nil
+}
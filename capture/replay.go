@@ -0,0 +1,235 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/chenqinghe/sockit"
+)
+
+// ReplayOptions configures replaying.
+type ReplayOptions struct {
+	// Speed is the multiple of original speed, e.g. 2 means two times faster.
+	// Zero means replay as fast as possible.
+	Speed float64
+
+	// Sessions specify ids of sessions to replay, all sessions are replayed if empty.
+	Sessions []int64
+}
+
+// ReadAll reads all records until io.EOF.
+func (r *Reader) ReadAll() ([]Record, error) {
+	var records []Record
+	for {
+		rec, err := r.ReadRecord()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
+
+// ReplayHandler decodes frames read by recorded sessions with codec, and passes packets to
+// handler as if they were received by live sessions, each recorded session is replayed
+// by a new Session concurrently. Packets sent by handler are discarded.
+// It returns after all packets handled or ctx done.
+func ReplayHandler(ctx context.Context, records []Record, codec sockit.Codec, handler sockit.Handler, opts *ReplayOptions) error {
+	sessions, start := groupRecords(records, opts)
+
+	mgr := sockit.NewManager(handler, &sockit.NewManagerOptions{Logger: sockit.NopLogger{}})
+
+	drained := &sync.WaitGroup{}
+	for _, recs := range sessions {
+		drained.Add(1)
+		c := &replayConn{
+			ctx:     ctx,
+			codec:   codec,
+			records: recs,
+			timer:   newReplayTimer(start, opts),
+			drained: drained.Done,
+			closed:  make(chan struct{}),
+		}
+		if _, err := mgr.StoreConn(c); err != nil {
+			mgr.Close()
+			return err
+		}
+	}
+
+	drained.Wait()
+
+	// wait handlers finished
+	return mgr.Shutdown(ctx)
+}
+
+// ReplayServer dials the server for each recorded session and writes frames read by
+// the session, data sent by the server is discarded. It returns after all frames written
+// or ctx done.
+func ReplayServer(ctx context.Context, records []Record, network, addr string, opts *ReplayOptions) error {
+	sessions, start := groupRecords(records, opts)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for _, recs := range sessions {
+		wg.Add(1)
+		go func(recs []Record) {
+			defer wg.Done()
+			if err := replayToServer(ctx, recs, network, addr, newReplayTimer(start, opts)); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(recs)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func replayToServer(ctx context.Context, records []Record, network, addr string, timer *replayTimer) error {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	go io.Copy(ioutil.Discard, c)
+
+	for _, rec := range records {
+		if err := timer.wait(ctx, rec.Time); err != nil {
+			return err
+		}
+		if _, err := c.Write(rec.Frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupRecords groups records read by sessions by session id,
+// and returns the time of the first record.
+func groupRecords(records []Record, opts *ReplayOptions) (map[int64][]Record, time.Time) {
+	var only map[int64]bool
+	if opts != nil && len(opts.Sessions) > 0 {
+		only = make(map[int64]bool, len(opts.Sessions))
+		for _, id := range opts.Sessions {
+			only[id] = true
+		}
+	}
+
+	var start time.Time
+	sessions := make(map[int64][]Record)
+	for _, rec := range records {
+		if rec.Direction != sockit.DirectionIn || (only != nil && !only[rec.SessionId]) {
+			continue
+		}
+		if start.IsZero() || rec.Time.Before(start) {
+			start = rec.Time
+		}
+		sessions[rec.SessionId] = append(sessions[rec.SessionId], rec)
+	}
+	return sessions, start
+}
+
+// replayTimer waits until the time of a record arrives in replaying.
+type replayTimer struct {
+	speed       float64
+	start       time.Time // time of the first record
+	replayStart time.Time
+}
+
+func newReplayTimer(start time.Time, opts *ReplayOptions) *replayTimer {
+	t := &replayTimer{
+		start:       start,
+		replayStart: time.Now(),
+	}
+	if opts != nil {
+		t.speed = opts.Speed
+	}
+	return t
+}
+
+func (t *replayTimer) wait(ctx context.Context, at time.Time) error {
+	if t.speed <= 0 {
+		return ctx.Err()
+	}
+
+	due := t.replayStart.Add(time.Duration(float64(at.Sub(t.start)) / t.speed))
+	timer := time.NewTimer(time.Until(due))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// replayConn is a sockit.Conn which reads packets decoded from records.
+type replayConn struct {
+	ctx     context.Context
+	codec   sockit.Codec
+	records []Record
+	timer   *replayTimer
+	drained func() // called when all records read
+
+	drainOnce sync.Once
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+var _ sockit.Conn = (*replayConn)(nil)
+
+var replayAddr = &net.UnixAddr{Name: "replay", Net: "replay"}
+
+func (c *replayConn) LocalAddr() net.Addr  { return replayAddr }
+func (c *replayConn) RemoteAddr() net.Addr { return replayAddr }
+
+func (c *replayConn) SendPacket(p sockit.Packet) error {
+	return c.codec.Write(ioutil.Discard, p)
+}
+
+func (c *replayConn) ReadPacket() (sockit.Packet, error) {
+	select {
+	case <-c.closed:
+		return nil, net.ErrClosed
+	default:
+	}
+
+	// keep the session open until closed by Manager.Shutdown, so that it's closed
+	// after all handlers finished. net.ErrClosed makes the session closed silently.
+	if len(c.records) == 0 || c.timer.wait(c.ctx, c.records[0].Time) != nil {
+		c.records = nil
+		c.drainOnce.Do(c.drained)
+		<-c.closed
+		return nil, net.ErrClosed
+	}
+	rec := c.records[0]
+	c.records = c.records[1:]
+
+	return c.codec.Read(bufio.NewReader(bytes.NewReader(rec.Frame)))
+}
+
+func (c *replayConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	c.drainOnce.Do(c.drained)
+	return nil
+}
@@ -3,9 +3,9 @@ package sockit
 import (
 	"crypto/tls"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/sirupsen/logrus"
 )
 
 type Client struct {
@@ -13,6 +13,10 @@ type Client struct {
 	codec Codec
 
 	opts *NewClientOptions
+	log  Logger
+
+	hookLock       *sync.Mutex
+	reconnectHooks []func(s *Session)
 
 	closed chan struct{}
 }
@@ -26,6 +30,39 @@ type NewClientOptions struct {
 	OnClosed               func(session *Session)
 	NeedReconnect          bool
 	ReconnectPolicy        ReconnectPolicy
+
+	// Middlewares wrap the handler, the first one is the outermost.
+	Middlewares []Middleware
+
+	// DispatchMode, WorkerPoolSize, DispatchQueueSize, DispatchKeyFunc and
+	// DispatchConcurrent are same as fields of NewManagerOptions.
+	DispatchMode       DispatchMode
+	WorkerPoolSize     int
+	DispatchQueueSize  int
+	DispatchKeyFunc    func(p Packet) string
+	DispatchConcurrent int
+
+	// SendQueueSize and SendQueuePolicy are same as fields of NewManagerOptions.
+	SendQueueSize   int
+	SendQueuePolicy OverflowPolicy
+
+	// ConnOptions configures buffering of dialed connections.
+	ConnOptions *ConnOptions
+
+	// Correlator matches responses with requests. Default is MarkerCorrelator.
+	Correlator Correlator
+
+	// Metrics collects metrics of sessions, connections and handlers.
+	Metrics MetricsCollector
+
+	// Logger is used to log events of client and sessions. Default is DefaultLogger.
+	Logger Logger
+
+	// Tracer traces sending requests, decoding and handling packets.
+	Tracer Tracer
+
+	// Tap observes packets of all sessions.
+	Tap Tap
 }
 
 func NewClient(codec Codec, handler Handler, opts *NewClientOptions) *Client {
@@ -37,12 +74,30 @@ func NewClient(codec Codec, handler Handler, opts *NewClientOptions) *Client {
 	}
 
 	cli := &Client{
-		opts:   opts,
-		codec:  codec,
-		closed: make(chan struct{}),
+		opts:     opts,
+		log:      opts.Logger,
+		hookLock: &sync.Mutex{},
+		codec:    codec,
+		closed:   make(chan struct{}),
+	}
+	if cli.log == nil {
+		cli.log = DefaultLogger
 	}
 	cli.mgr = NewManager(handler, &NewManagerOptions{
-		OnSessionCreated: opts.OnSessionCreated,
+		OnSessionCreated:   opts.OnSessionCreated,
+		Middlewares:        opts.Middlewares,
+		DispatchMode:       opts.DispatchMode,
+		WorkerPoolSize:     opts.WorkerPoolSize,
+		DispatchQueueSize:  opts.DispatchQueueSize,
+		DispatchKeyFunc:    opts.DispatchKeyFunc,
+		DispatchConcurrent: opts.DispatchConcurrent,
+		SendQueueSize:      opts.SendQueueSize,
+		SendQueuePolicy:    opts.SendQueuePolicy,
+		Correlator:         opts.Correlator,
+		Metrics:            opts.Metrics,
+		Logger:             cli.log,
+		Tracer:             opts.Tracer,
+		Tap:                opts.Tap,
 		AfterSessionClosed: func(s *Session) {
 			cli.reconnect(s)
 		},
@@ -61,7 +116,7 @@ func (cli *Client) Dial(network string, addr string) (*Session, error) {
 		return nil, err
 	}
 
-	conn := newConn(c, cli.codec)
+	conn := newConn(c, cli.codec, cli.opts.ConnOptions)
 	if cli.opts.OnConnected != nil {
 		if err := cli.opts.OnConnected(conn); err != nil {
 			return nil, err
@@ -76,7 +131,7 @@ func (cli *Client) DialTLS(network string, addr string, config *tls.Config) (*Se
 	if err != nil {
 		return nil, err
 	}
-	conn := newConn(c, cli.codec)
+	conn := newConn(c, cli.codec, cli.opts.ConnOptions)
 	if cli.opts.OnConnected != nil {
 		if err := cli.opts.OnConnected(conn); err != nil {
 			return nil, err
@@ -92,7 +147,7 @@ func (cli *Client) DialTimeout(network string, addr string, timeout time.Duratio
 		return nil, err
 	}
 
-	conn := newConn(c, cli.codec)
+	conn := newConn(c, cli.codec, cli.opts.ConnOptions)
 	if cli.opts.OnConnected != nil {
 		if err := cli.opts.OnConnected(conn); err != nil {
 			return nil, err
@@ -112,21 +167,19 @@ func (cli *Client) reconnect(sess *Session) {
 	policy := cli.opts.ReconnectPolicy
 
 	for cli.needReconnect(sess) && policy.Retry() {
-		logrus.WithField("sessionId", sess.id).WithField("remoteAddr", sess.RemoteAddr().String()).Debugln("session reconnect")
+		log := cli.log.With("sessionId", sess.id, "remoteAddr", addr.String())
+		log.Debug("session reconnect")
 
 		if s, err := cli.DialTimeout(addr.Network(), addr.String(), time.Second*5); err == nil {
 			s.data = sess.data
 			s.user = sess.user
-			s.lastPackTs = sess.lastPackTs
-			s.requests = sess.requests
+			s.lastPackTs = atomic.LoadInt64(&sess.lastPackTs)
 			*sess = *s // replace old session
-			logrus.WithFields(logrus.Fields{"remoteAddr": sess.RemoteAddr().String(), "sessionId": sess.id}).Debugln("reconnect successful")
+			sess.Logger().Debug("reconnect successful")
+			cli.runReconnectHooks(sess)
 			return
 		} else {
-			logrus.WithFields(logrus.Fields{
-				"remoteAddr": sess.RemoteAddr().String(),
-				"sessionId":  sess.id,
-			}).Errorln("reconnect error", err)
+			log.Error("reconnect error", "error", err.Error())
 		}
 
 		timer := policy.Timer()
@@ -140,11 +193,37 @@ func (cli *Client) reconnect(sess *Session) {
 	}
 }
 
+// OnReconnect registers a function which will be called with the session
+// after it reconnected successfully.
+func (cli *Client) OnReconnect(fn func(s *Session)) {
+	cli.hookLock.Lock()
+	defer cli.hookLock.Unlock()
+
+	cli.reconnectHooks = append(cli.reconnectHooks, fn)
+}
+
+func (cli *Client) runReconnectHooks(sess *Session) {
+	cli.hookLock.Lock()
+	hooks := make([]func(s *Session), len(cli.reconnectHooks))
+	copy(hooks, cli.reconnectHooks)
+	cli.hookLock.Unlock()
+
+	for _, fn := range hooks {
+		fn(sess)
+	}
+}
+
 func (cli *Client) needReconnect(sess *Session) bool {
 	if !cli.opts.NeedReconnect {
 		return false
 	}
 
+	select {
+	case <-cli.closed:
+		return false
+	default:
+	}
+
 	return !sess.manuallyClosed
 }
 
@@ -155,15 +234,9 @@ func (cli *Client) heartbeat() {
 		case <-ticker.C:
 			cli.mgr.RangeSession(func(s *Session) {
 				if err := s.SendPacket(cli.opts.HeartbeatPacketFactory()); err != nil {
-					logrus.WithFields(logrus.Fields{
-						"sessionId":  s.Id(),
-						"remoteAddr": s.RemoteAddr().String(),
-					}).Errorln("send heartbeat packet error:", err.Error())
+					s.Logger().Error("send heartbeat packet error", "error", err.Error())
 					if err := cli.mgr.RemoveSession(s.Id()); err != nil {
-						logrus.WithFields(logrus.Fields{
-							"sessionId":  s.Id(),
-							"remoteAddr": s.RemoteAddr().String(),
-						}).Errorln("remove session error:", err.Error())
+						s.Logger().Error("remove session error", "error", err.Error())
 					}
 				}
 			})
@@ -174,6 +247,13 @@ func (cli *Client) heartbeat() {
 	}
 }
 
+// SetTap sets Tap of all current and future sessions, nil means disable it.
+func (cli *Client) SetTap(t Tap) {
+	if m, ok := cli.mgr.(*Manager); ok {
+		m.SetTap(t)
+	}
+}
+
 func (cli *Client) FindSession(id int64) (*Session, bool) { return cli.mgr.FindSession(id) }
 func (cli *Client) RangeSession(fn func(sess *Session))   { cli.mgr.RangeSession(fn) }
 
@@ -0,0 +1,51 @@
+package sockit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientReconnect(t *testing.T) {
+	received := make(chan Packet, 16)
+	mgr := NewManager(chanHandler(received), nil)
+	_, addr := startServer(t, mgr)
+
+	reconnected := make(chan struct{}, 4)
+	cli := NewClient(testCodec{}, HandlerFunc(func(Packet, *Session) {}), &NewClientOptions{
+		NeedReconnect:   true,
+		ReconnectPolicy: constPolicy(10 * time.Millisecond),
+	})
+	defer cli.Close()
+	cli.OnReconnect(func(s *Session) { reconnected <- struct{}{} })
+
+	sess, err := cli.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.SendPacketAsync(testPacket{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	recvPacket(t, received)
+
+	// the server closes the connection once
+	mgr.RangeSession(func(s *Session) { mgr.RemoveSession(s.Id()) })
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting reconnect")
+	}
+
+	// the writer of the old connection must not close the new one
+	for id := int64(2); id <= 3; id++ {
+		if err := sess.SendPacketAsync(testPacket{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+		if p := recvPacket(t, received); p.Id() != id {
+			t.Fatalf("received packet %d, want %d", p.Id(), id)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := len(reconnected); n != 0 {
+		t.Fatalf("reconnected %d more times", n)
+	}
+}
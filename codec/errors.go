@@ -0,0 +1,51 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidChecksum = errors.New("invalid checksum")
+
+var ErrInvalidLength = errors.New("invalid frame length")
+
+// ErrResyncWithoutLabel is returned by TLVCodec in Resync mode if Label
+// is not set for TLVVersion1 frames.
+var ErrResyncWithoutLabel = errors.New("tlv resync requires label for version 1 frames")
+
+var errInvalidTraceContext = errors.New("invalid trace context")
+
+// FrameTooLargeError is returned when a frame exceeds the size limit of codec.
+// The connection should be closed, since the rest of frame is not consumed.
+type FrameTooLargeError struct {
+	// Size is the size of the frame, for delimited frames it's the size
+	// read before exceeding the limit.
+	Size uint64
+
+	// Limit is the max size permitted.
+	Limit uint64
+}
+
+func (e *FrameTooLargeError) Error() string {
+	return fmt.Sprintf("frame too large: %d bytes exceeds limit %d", e.Size, e.Limit)
+}
+
+// UnsupportedVersionError is returned when the version of frame
+// or codec is unknown or rejected by codec.
+type UnsupportedVersionError struct {
+	Version uint16
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("unsupported version: %d", e.Version)
+}
+
+// InvalidLabelError is returned when the magic label of frame is wrong.
+type InvalidLabelError struct {
+	Label uint16
+	Want  uint16
+}
+
+func (e *InvalidLabelError) Error() string {
+	return fmt.Sprintf("invalid label: %#04x, want %#04x", e.Label, e.Want)
+}
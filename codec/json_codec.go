@@ -1,9 +1,10 @@
 package codec
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
-	"github.com/sirupsen/logrus"
+	"errors"
 	"io"
 	"time"
 
@@ -12,8 +13,18 @@ import (
 
 type JsonCodec struct {
 	Delimiter string
+
+	// Logger is used to log debug data. Default is sockit.DefaultLogger.
+	Logger sockit.Logger
+
+	// MaxLineSize is the max length of a line including delimiter, lines exceeding it
+	// are rejected with *FrameTooLargeError. Default is DefaultMaxLineSize.
+	MaxLineSize int
 }
 
+// DefaultMaxLineSize is the default max length of a line of JsonCodec.
+const DefaultMaxLineSize = 16 << 20
+
 type JsonPacket struct {
 	Type      int8            `json:"type"`
 	Version   uint8           `json:"version"`
@@ -22,13 +33,116 @@ type JsonPacket struct {
 	ID        int64           `json:"id"`
 	Timestamp int64           `json:"time"`
 	Data      json.RawMessage `json:"data"`
+
+	// TraceContext is the W3C traceparent of the packet, used for tracing.
+	TraceContext string `json:"traceparent,omitempty"`
+
+	// Response marks the packet as a response.
+	Response bool `json:"response,omitempty"`
 }
 
 func (p JsonPacket) IsKeepAlive() bool { return p.Subject == 0 }
 func (p JsonPacket) Id() int64         { return p.ID }
 func (p JsonPacket) Time() time.Time   { return time.Unix(p.Timestamp, 0) }
+func (p JsonPacket) IsResponse() bool  { return p.Response }
+
+func (p JsonPacket) TraceParent() string { return p.TraceContext }
+
+func (p JsonPacket) WithTraceParent(tp string) sockit.Packet {
+	p.TraceContext = tp
+	return p
+}
+
+// JsonCorrelator correlates JsonPacket request and response by ID.
+type JsonCorrelator struct {
+	// ResponseType is the Type of response packets, for protocols marking
+	// responses by Type. If nil, packets with Response set are responses.
+	ResponseType *int8
+}
+
+var _ sockit.Correlator = JsonCorrelator{}
+
+func (JsonCorrelator) RequestKey(p sockit.Packet) int64  { return p.Id() }
+func (JsonCorrelator) ResponseKey(p sockit.Packet) int64 { return p.Id() }
+
+func (c JsonCorrelator) IsResponse(p sockit.Packet) bool {
+	pkt, ok := p.(JsonPacket)
+	if !ok {
+		return false
+	}
+	if c.ResponseType != nil {
+		return pkt.Type == *c.ResponseType
+	}
+	return pkt.Response
+}
+
+func (codec JsonCodec) logger() sockit.Logger {
+	if codec.Logger == nil {
+		return sockit.DefaultLogger
+	}
+	return codec.Logger
+}
+
+func (codec JsonCodec) maxLineSize() int {
+	if codec.MaxLineSize <= 0 {
+		return DefaultMaxLineSize
+	}
+	return codec.MaxLineSize
+}
 
 func (codec *JsonCodec) Read(reader io.Reader) (sockit.Packet, error) {
+	if codec.Delimiter == "" {
+		return nil, errors.New("json codec delimiter is empty")
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if br, ok := reader.(sockit.BufferedReader); ok {
+		data, err = codec.readFrame(br)
+	} else {
+		data, err = codec.readFrameByByte(reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	codec.logger().Debug("read data", "data", string(data))
+
+	var p JsonPacket
+
+	if err := json.Unmarshal(data[:len(data)-len(codec.Delimiter)], &p); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// readFrame reads data until delimiter with the buffered reader.
+func (codec *JsonCodec) readFrame(br sockit.BufferedReader) ([]byte, error) {
+	delim := []byte(codec.Delimiter)
+	last := delim[len(delim)-1]
+
+	var data []byte
+	for {
+		line, err := br.ReadSlice(last)
+		if err != nil && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		data = append(data, line...)
+		if err == nil && bytes.HasSuffix(data, delim) {
+			return data, nil
+		}
+		if len(data) >= codec.maxLineSize() {
+			return nil, &FrameTooLargeError{Size: uint64(len(data)), Limit: uint64(codec.maxLineSize())}
+		}
+	}
+}
+
+// readFrameByByte reads data until delimiter one byte at a time,
+// it's used when the reader is not buffered.
+func (codec *JsonCodec) readFrameByByte(reader io.Reader) ([]byte, error) {
 	data := make([]byte, 0, 4096)
 	buf := make([]byte, 1)
 
@@ -39,19 +153,12 @@ func (codec *JsonCodec) Read(reader io.Reader) (sockit.Packet, error) {
 		data = append(data, buf...)
 		if len(data) >= len(codec.Delimiter) &&
 			bytes.Equal([]byte(codec.Delimiter), data[len(data)-len(codec.Delimiter):]) {
-			break
+			return data, nil
+		}
+		if len(data) >= codec.maxLineSize() {
+			return nil, &FrameTooLargeError{Size: uint64(len(data)), Limit: uint64(codec.maxLineSize())}
 		}
 	}
-
-	logrus.Debug("final data: " + string(data))
-
-	var p JsonPacket
-
-	if err := json.Unmarshal(data[:len(data)-len(codec.Delimiter)], &p); err != nil {
-		return nil, err
-	}
-
-	return p, nil
 }
 
 func (codec JsonCodec) Write(writer io.Writer, p sockit.Packet) error {
@@ -61,8 +168,11 @@ func (codec JsonCodec) Write(writer io.Writer, p sockit.Packet) error {
 	}
 
 	data = append(data, codec.Delimiter...)
+	if len(data) > codec.maxLineSize() {
+		return &FrameTooLargeError{Size: uint64(len(data)), Limit: uint64(codec.maxLineSize())}
+	}
 
-	logrus.Debug("write data: " + string(data))
+	codec.logger().Debug("write data", "data", string(data))
 
 	if _, err := writer.Write(data); err != nil {
 		return err
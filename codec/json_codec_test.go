@@ -0,0 +1,97 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/chenqinghe/sockit"
+)
+
+func TestJsonCorrelator(t *testing.T) {
+	respType := int8(2)
+	tests := []struct {
+		correlator JsonCorrelator
+		packet     JsonPacket
+		want       bool
+	}{
+		{JsonCorrelator{}, JsonPacket{}, false},
+		{JsonCorrelator{}, JsonPacket{Response: true}, true},
+		{JsonCorrelator{ResponseType: &respType}, JsonPacket{Type: 2}, true},
+		{JsonCorrelator{ResponseType: &respType}, JsonPacket{Type: 0}, false},
+		{JsonCorrelator{ResponseType: &respType}, JsonPacket{Response: true}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.correlator.IsResponse(tt.packet); got != tt.want {
+			t.Errorf("IsResponse(%+v) = %v, want %v", tt.packet, got, tt.want)
+		}
+	}
+}
+
+// jsonReaders returns a buffered reader smaller than lines of test, and an unbuffered reader.
+func jsonReaders(s string) map[string]io.Reader {
+	return map[string]io.Reader{
+		"buffered":   bufio.NewReaderSize(strings.NewReader(s), 16),
+		"unbuffered": struct{ io.Reader }{strings.NewReader(s)},
+	}
+}
+
+func TestJsonCodecDelimiter(t *testing.T) {
+	// the last byte of delimiter occurs inside lines
+	const stream = "{\"id\":1,\n\"subject\":1,\"data\":\"a\\r\\n\"}\r\n" +
+		"{\"id\":2,\"subject\":1,\"data\":\"" + "0123456789abcdef0123456789abcdef" + "\"}\r\n"
+
+	for name, r := range jsonReaders(stream) {
+		if _, ok := r.(sockit.BufferedReader); ok != (name == "buffered") {
+			t.Fatalf("%s reader is not %s", name, name)
+		}
+		c := &JsonCodec{Delimiter: "\r\n"}
+		for _, id := range []int64{1, 2} {
+			p, err := c.Read(r)
+			if err != nil {
+				t.Fatalf("%s: read packet %d: %v", name, id, err)
+			}
+			if p.Id() != id {
+				t.Fatalf("%s: read packet %d, want %d", name, p.Id(), id)
+			}
+		}
+		if _, err := c.Read(r); err != io.EOF {
+			t.Fatalf("%s: err = %v, want io.EOF", name, err)
+		}
+	}
+}
+
+func TestJsonCodecMaxLineSize(t *testing.T) {
+	c := &JsonCodec{Delimiter: "\n", MaxLineSize: 100}
+
+	var buf bytes.Buffer
+	if err := c.Write(&buf, JsonPacket{ID: 1, Subject: 1}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	line := buf.String()
+
+	large := JsonPacket{ID: 1, Subject: 1, Data: []byte(`"` + strings.Repeat("x", 100) + `"`)}
+	buf.Reset()
+	if err := (&JsonCodec{Delimiter: "\n"}).Write(&buf, large); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	var fe *FrameTooLargeError
+	err := c.Write(&bytes.Buffer{}, large)
+	if !errors.As(err, &fe) || fe.Size != uint64(buf.Len()) || fe.Limit != 100 {
+		t.Fatalf("write: err = %v, want *FrameTooLargeError", err)
+	}
+
+	long := `{"id":2,"data":"` + strings.Repeat("x", 200) + "\"}\n"
+	for name, r := range jsonReaders(line + long) {
+		if p, err := c.Read(r); err != nil || p.Id() != 1 {
+			t.Fatalf("%s: read = %v, %v", name, p, err)
+		}
+		_, err := c.Read(r)
+		if !errors.As(err, &fe) || fe.Size < 100 || fe.Size >= uint64(len(long)) || fe.Limit != 100 {
+			t.Fatalf("%s: err = %v, want *FrameTooLargeError", name, err)
+		}
+	}
+}
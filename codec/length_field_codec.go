@@ -0,0 +1,295 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/chenqinghe/sockit"
+)
+
+// LengthFieldVarint specifies the length field is an unsigned varint
+// as encoded by binary.PutUvarint.
+const LengthFieldVarint = -1
+
+// LengthFieldCodec is a generic codec of frames with a length field, like
+// LengthFieldBasedFrameDecoder of Netty. A frame is composed of a header and
+// a payload, the header is LengthFieldOffset bytes followed by the length field:
+//
+//	+---------------------+--------------+-----------+
+//	| LengthFieldOffset   | length field |  payload  |
+//	+---------------------+--------------+-----------+
+//	|<------------- header ------------->|
+//
+// The length of payload is calculated by: value of length field + LengthAdjustment,
+// minus the length of header if LengthIncludesHeader is true.
+//
+//	// 2 bytes type, 4 bytes little endian length of the whole frame
+//	codec.LengthFieldCodec{
+//		LengthFieldOffset:    2,
+//		LengthFieldLength:    4,
+//		ByteOrder:            binary.LittleEndian,
+//		LengthIncludesHeader: true,
+//	}
+type LengthFieldCodec struct {
+	// LengthFieldOffset is the offset of length field.
+	LengthFieldOffset int
+
+	// LengthFieldLength is the number of bytes of length field, it must be
+	// 1, 2, 4, 8 or LengthFieldVarint.
+	LengthFieldLength int
+
+	// ByteOrder of length field, default is binary.BigEndian.
+	ByteOrder binary.ByteOrder
+
+	// LengthAdjustment is added to the value of length field.
+	LengthAdjustment int
+
+	// LengthIncludesHeader indicates the value of length field
+	// includes the length of header.
+	LengthIncludesHeader bool
+
+	// MaxFrameSize is the max length of payload, frames exceeding it are
+	// rejected with *FrameTooLargeError. Default is DefaultMaxPacketSize.
+	MaxFrameSize int
+}
+
+// RawPacket is the packet of LengthFieldCodec.
+type RawPacket struct {
+	// ID is not carried on wire. Packets read have zero ID, it may be
+	// set for SendRequest working with a custom sockit.Correlator.
+	ID int64
+
+	// Timestamp is the time packet decoded.
+	Timestamp time.Time
+
+	// Header is the bytes before length field. It's padded with zero
+	// to LengthFieldOffset bytes when written.
+	Header []byte
+
+	Data []byte
+}
+
+func (p RawPacket) Id() int64       { return p.ID }
+func (p RawPacket) Time() time.Time { return p.Timestamp }
+
+func (c LengthFieldCodec) Read(reader io.Reader) (sockit.Packet, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, c.LengthFieldOffset)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	value, fieldLen, err := c.readLength(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	length := int64(value) + int64(c.LengthAdjustment)
+	if c.LengthIncludesHeader {
+		length -= int64(c.LengthFieldOffset + fieldLen)
+	}
+	if value > uint64(1<<62) || length < 0 {
+		return nil, fmt.Errorf("%w: length field %d", ErrInvalidLength, value)
+	}
+	if length > int64(c.maxFrameSize()) {
+		return nil, &FrameTooLargeError{Size: uint64(length), Limit: uint64(c.maxFrameSize())}
+	}
+
+	data, err := readData(reader, uint64(length))
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return RawPacket{
+		Timestamp: time.Now(),
+		Header:    header,
+		Data:      data,
+	}, nil
+}
+
+// readLength reads the length field, returns its value and number of bytes.
+func (c LengthFieldCodec) readLength(reader io.Reader) (uint64, int, error) {
+	if c.LengthFieldLength == LengthFieldVarint {
+		br, ok := reader.(io.ByteReader)
+		if !ok {
+			br = byteReader{reader}
+		}
+		n := 0
+		value, err := binary.ReadUvarint(countingByteReader{br, &n})
+		if err != nil {
+			if err == io.EOF && n > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, n, err
+		}
+		return value, n, nil
+	}
+
+	field := make([]byte, c.LengthFieldLength)
+	if _, err := io.ReadFull(reader, field); err != nil {
+		return 0, 0, err
+	}
+
+	order := c.byteOrder()
+	switch c.LengthFieldLength {
+	case 1:
+		return uint64(field[0]), 1, nil
+	case 2:
+		return uint64(order.Uint16(field)), 2, nil
+	case 4:
+		return uint64(order.Uint32(field)), 4, nil
+	default:
+		return order.Uint64(field), 8, nil
+	}
+}
+
+func (c LengthFieldCodec) Write(writer io.Writer, p sockit.Packet) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	pkt, ok := p.(RawPacket)
+	if !ok {
+		return fmt.Errorf("unknown packet type: %s", reflect.TypeOf(p).String())
+	}
+	if len(pkt.Header) > c.LengthFieldOffset {
+		return fmt.Errorf("header too long: %d bytes exceeds length field offset %d", len(pkt.Header), c.LengthFieldOffset)
+	}
+	if len(pkt.Data) > c.maxFrameSize() {
+		return &FrameTooLargeError{Size: uint64(len(pkt.Data)), Limit: uint64(c.maxFrameSize())}
+	}
+
+	value := int64(len(pkt.Data)) - int64(c.LengthAdjustment)
+	if c.LengthIncludesHeader {
+		value += int64(c.LengthFieldOffset + c.fieldLen(value))
+	}
+	if value < 0 {
+		return fmt.Errorf("%w: length field %d", ErrInvalidLength, value)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, c.LengthFieldOffset+binary.MaxVarintLen64+len(pkt.Data)))
+	buf.Write(pkt.Header)
+	buf.Write(make([]byte, c.LengthFieldOffset-len(pkt.Header)))
+
+	field := make([]byte, binary.MaxVarintLen64)
+	order := c.byteOrder()
+	switch c.LengthFieldLength {
+	case LengthFieldVarint:
+		field = field[:binary.PutUvarint(field, uint64(value))]
+	case 1:
+		if value > 0xff {
+			return fmt.Errorf("%w: length field %d overflows 1 byte", ErrInvalidLength, value)
+		}
+		field = []byte{byte(value)}
+	case 2:
+		if value > 0xffff {
+			return fmt.Errorf("%w: length field %d overflows 2 bytes", ErrInvalidLength, value)
+		}
+		field = field[:2]
+		order.PutUint16(field, uint16(value))
+	case 4:
+		if value > 0xffffffff {
+			return fmt.Errorf("%w: length field %d overflows 4 bytes", ErrInvalidLength, value)
+		}
+		field = field[:4]
+		order.PutUint32(field, uint32(value))
+	default:
+		field = field[:8]
+		order.PutUint64(field, uint64(value))
+	}
+	buf.Write(field)
+	buf.Write(pkt.Data)
+
+	_, err := writer.Write(buf.Bytes())
+	return err
+}
+
+// fieldLen returns the number of bytes of length field, where payload is
+// the value of length field excluding the header.
+func (c LengthFieldCodec) fieldLen(payload int64) int {
+	if c.LengthFieldLength != LengthFieldVarint {
+		return c.LengthFieldLength
+	}
+
+	// the width of varint depends on the value including itself
+	n := 1
+	for {
+		value := payload + int64(c.LengthFieldOffset+n)
+		if value < 0 {
+			value = 0
+		}
+		if w := uvarintLen(uint64(value)); w <= n {
+			return n
+		}
+		n++
+	}
+}
+
+func uvarintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+func (c LengthFieldCodec) validate() error {
+	switch c.LengthFieldLength {
+	case 1, 2, 4, 8, LengthFieldVarint:
+	default:
+		return fmt.Errorf("invalid length field length: %d", c.LengthFieldLength)
+	}
+	if c.LengthFieldOffset < 0 {
+		return fmt.Errorf("invalid length field offset: %d", c.LengthFieldOffset)
+	}
+	return nil
+}
+
+func (c LengthFieldCodec) byteOrder() binary.ByteOrder {
+	if c.ByteOrder == nil {
+		return binary.BigEndian
+	}
+	return c.ByteOrder
+}
+
+func (c LengthFieldCodec) maxFrameSize() int {
+	if c.MaxFrameSize <= 0 {
+		return DefaultMaxPacketSize
+	}
+	return c.MaxFrameSize
+}
+
+// byteReader reads one byte at a time from an unbuffered reader.
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r.Reader, b[:])
+	return b[0], err
+}
+
+// countingByteReader counts bytes read.
+type countingByteReader struct {
+	br io.ByteReader
+	n  *int
+}
+
+func (r countingByteReader) ReadByte() (byte, error) {
+	b, err := r.br.ReadByte()
+	if err == nil {
+		*r.n++
+	}
+	return b, err
+}
@@ -0,0 +1,121 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestLengthFieldCodec(t *testing.T) {
+	tests := []struct {
+		name   string
+		codec  LengthFieldCodec
+		header []byte
+		size   int    // size of data
+		want   []byte // header and length field on wire
+	}{
+		{"1 byte", LengthFieldCodec{LengthFieldLength: 1}, nil, 5, []byte{5}},
+		{"1 byte max", LengthFieldCodec{LengthFieldLength: 1}, nil, 255, []byte{0xff}},
+		{"2 bytes big endian", LengthFieldCodec{LengthFieldLength: 2}, nil, 0x102, []byte{1, 2}},
+		{"2 bytes little endian", LengthFieldCodec{LengthFieldLength: 2, ByteOrder: binary.LittleEndian}, nil, 0x102, []byte{2, 1}},
+		{"4 bytes big endian", LengthFieldCodec{LengthFieldLength: 4}, nil, 0x102, []byte{0, 0, 1, 2}},
+		{"4 bytes little endian", LengthFieldCodec{LengthFieldLength: 4, ByteOrder: binary.LittleEndian}, nil, 0x102, []byte{2, 1, 0, 0}},
+		{"8 bytes big endian", LengthFieldCodec{LengthFieldLength: 8}, nil, 0x102, []byte{0, 0, 0, 0, 0, 0, 1, 2}},
+		{"8 bytes little endian", LengthFieldCodec{LengthFieldLength: 8, ByteOrder: binary.LittleEndian}, nil, 0x102, []byte{2, 1, 0, 0, 0, 0, 0, 0}},
+		{"varint 0", LengthFieldCodec{LengthFieldLength: LengthFieldVarint}, nil, 0, []byte{0}},
+		{"varint 127", LengthFieldCodec{LengthFieldLength: LengthFieldVarint}, nil, 127, []byte{0x7f}},
+		{"varint 128", LengthFieldCodec{LengthFieldLength: LengthFieldVarint}, nil, 128, []byte{0x80, 0x01}},
+		{"varint 16383", LengthFieldCodec{LengthFieldLength: LengthFieldVarint}, nil, 16383, []byte{0xff, 0x7f}},
+		{"varint 16384", LengthFieldCodec{LengthFieldLength: LengthFieldVarint}, nil, 16384, []byte{0x80, 0x80, 0x01}},
+
+		{"offset", LengthFieldCodec{LengthFieldOffset: 2, LengthFieldLength: 2}, []byte{0xa, 0xb}, 5, []byte{0xa, 0xb, 0, 5}},
+		{"offset padded", LengthFieldCodec{LengthFieldOffset: 2, LengthFieldLength: 2}, []byte{0xa}, 5, []byte{0xa, 0, 0, 5}},
+
+		{"positive adjustment", LengthFieldCodec{LengthFieldLength: 1, LengthAdjustment: 3}, nil, 5, []byte{2}},
+		{"negative adjustment", LengthFieldCodec{LengthFieldLength: 1, LengthAdjustment: -2}, nil, 5, []byte{7}},
+		{"negative adjustment varint", LengthFieldCodec{LengthFieldLength: LengthFieldVarint, LengthAdjustment: -1}, nil, 127, []byte{0x80, 0x01}},
+
+		{"includes header", LengthFieldCodec{LengthFieldOffset: 2, LengthFieldLength: 4, ByteOrder: binary.LittleEndian, LengthIncludesHeader: true}, []byte{1, 2}, 5, []byte{1, 2, 11, 0, 0, 0}},
+		{"includes header and adjustment", LengthFieldCodec{LengthFieldLength: 2, LengthIncludesHeader: true, LengthAdjustment: -2}, nil, 5, []byte{0, 9}},
+		// the width of varint is included in its value
+		{"includes header varint 126", LengthFieldCodec{LengthFieldLength: LengthFieldVarint, LengthIncludesHeader: true}, nil, 126, []byte{0x7f}},
+		{"includes header varint 127", LengthFieldCodec{LengthFieldLength: LengthFieldVarint, LengthIncludesHeader: true}, nil, 127, []byte{0x81, 0x01}},
+		{"includes header varint 16381", LengthFieldCodec{LengthFieldLength: LengthFieldVarint, LengthIncludesHeader: true}, nil, 16381, []byte{0xff, 0x7f}},
+		{"includes header varint 16382", LengthFieldCodec{LengthFieldLength: LengthFieldVarint, LengthIncludesHeader: true}, nil, 16382, []byte{0x81, 0x80, 0x01}},
+		{"includes header varint offset", LengthFieldCodec{LengthFieldOffset: 2, LengthFieldLength: LengthFieldVarint, LengthIncludesHeader: true}, []byte{1, 2}, 124, []byte{1, 2, 0x7f}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Repeat([]byte{0x5a}, tt.size)
+
+			var buf bytes.Buffer
+			if err := tt.codec.Write(&buf, RawPacket{Header: tt.header, Data: data}); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			frame := buf.Bytes()
+			if !bytes.Equal(frame[:len(frame)-tt.size], tt.want) {
+				t.Fatalf("header = %x, want %x", frame[:len(frame)-tt.size], tt.want)
+			}
+
+			// a following frame must not be consumed
+			buf.WriteString("next")
+			p, err := tt.codec.Read(&buf)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			got := p.(RawPacket)
+			if !bytes.Equal(got.Header, tt.want[:tt.codec.LengthFieldOffset]) {
+				t.Errorf("header = %x, want %x", got.Header, tt.want[:tt.codec.LengthFieldOffset])
+			}
+			if !bytes.Equal(got.Data, data) {
+				t.Errorf("data length = %d, want %d", len(got.Data), tt.size)
+			}
+			if buf.String() != "next" {
+				t.Errorf("rest = %q, want %q", buf.String(), "next")
+			}
+		})
+	}
+}
+
+func TestLengthFieldCodecErrors(t *testing.T) {
+	write := func(c LengthFieldCodec, p RawPacket) error {
+		return c.Write(&bytes.Buffer{}, p)
+	}
+	read := func(c LengthFieldCodec, frame []byte) error {
+		_, err := c.Read(bytes.NewReader(frame))
+		return err
+	}
+
+	if err := write(LengthFieldCodec{LengthFieldLength: 1}, RawPacket{Data: make([]byte, 256)}); !errors.Is(err, ErrInvalidLength) {
+		t.Errorf("1 byte overflow: err = %v, want ErrInvalidLength", err)
+	}
+	if err := write(LengthFieldCodec{LengthFieldLength: 2}, RawPacket{Data: make([]byte, 0x10000)}); !errors.Is(err, ErrInvalidLength) {
+		t.Errorf("2 bytes overflow: err = %v, want ErrInvalidLength", err)
+	}
+	if err := write(LengthFieldCodec{LengthFieldLength: 1, LengthAdjustment: 10}, RawPacket{Data: make([]byte, 5)}); !errors.Is(err, ErrInvalidLength) {
+		t.Errorf("negative length field: err = %v, want ErrInvalidLength", err)
+	}
+	if err := write(LengthFieldCodec{LengthFieldLength: 1}, RawPacket{Header: []byte{1}}); err == nil {
+		t.Error("header longer than offset: err = nil")
+	}
+	if err := write(LengthFieldCodec{LengthFieldLength: 3}, RawPacket{}); err == nil {
+		t.Error("invalid field length: err = nil")
+	}
+
+	// length field smaller than header
+	if err := read(LengthFieldCodec{LengthFieldOffset: 2, LengthFieldLength: 1, LengthIncludesHeader: true}, []byte{0, 0, 2}); !errors.Is(err, ErrInvalidLength) {
+		t.Errorf("length less than header: err = %v, want ErrInvalidLength", err)
+	}
+	if err := read(LengthFieldCodec{LengthFieldLength: 1, LengthAdjustment: -3}, []byte{2}); !errors.Is(err, ErrInvalidLength) {
+		t.Errorf("negative length: err = %v, want ErrInvalidLength", err)
+	}
+
+	var fe *FrameTooLargeError
+	if err := read(LengthFieldCodec{LengthFieldLength: 2, MaxFrameSize: 10}, []byte{0, 11}); !errors.As(err, &fe) || fe.Size != 11 || fe.Limit != 10 {
+		t.Errorf("frame too large: err = %v, want *FrameTooLargeError", err)
+	}
+	if err := write(LengthFieldCodec{LengthFieldLength: 2, MaxFrameSize: 10}, RawPacket{Data: make([]byte, 11)}); !errors.As(err, &fe) {
+		t.Errorf("write frame too large: err = %v, want *FrameTooLargeError", err)
+	}
+}
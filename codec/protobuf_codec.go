@@ -0,0 +1,275 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/chenqinghe/sockit"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ProtobufResponseFlag is set in Type of response packet.
+const ProtobufResponseFlag int32 = 1 << 30
+
+var (
+	ErrUnregisteredType = errors.New("unregistered message type")
+	ErrAmbiguousType    = errors.New("message type registered with multiple tags")
+	ErrTypeMismatch     = errors.New("message type mismatches type tag")
+)
+
+// ProtobufRegistry maps type tags to protobuf message types.
+type ProtobufRegistry struct {
+	mu    *sync.RWMutex
+	types map[int32]protoreflect.MessageType
+	tags  map[protoreflect.FullName][]int32
+}
+
+func NewProtobufRegistry() *ProtobufRegistry {
+	return &ProtobufRegistry{
+		mu:    &sync.RWMutex{},
+		types: make(map[int32]protoreflect.MessageType),
+		tags:  make(map[protoreflect.FullName][]int32),
+	}
+}
+
+// Register registers the type of m with tag. A tag can be registered only once,
+// but a message type can be registered with multiple tags. Responses are tagged
+// with ProtobufResponseFlag set, so their types should be registered with the flag too.
+func (r *ProtobufRegistry) Register(tag int32, m proto.Message) error {
+	mt := m.ProtoReflect().Type()
+	name := mt.Descriptor().FullName()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.types[tag]; ok {
+		return fmt.Errorf("message type tag %d already registered", tag)
+	}
+	r.types[tag] = mt
+	r.tags[name] = append(r.tags[name], tag)
+	return nil
+}
+
+// Tag returns the tag of the type of m. It returns false if the type
+// is not registered or registered with multiple tags.
+func (r *ProtobufRegistry) Tag(m proto.Message) (int32, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tags := r.tags[m.ProtoReflect().Descriptor().FullName()]
+	if len(tags) != 1 {
+		return 0, false
+	}
+	return tags[0], true
+}
+
+// numTags returns the number of tags the type of m registered with.
+func (r *ProtobufRegistry) numTags(m proto.Message) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.tags[m.ProtoReflect().Descriptor().FullName()])
+}
+
+// New returns a new message of the type registered with tag.
+func (r *ProtobufRegistry) New(tag int32) (proto.Message, bool) {
+	mt, ok := r.typeOf(tag)
+	if !ok {
+		return nil, false
+	}
+	return mt.New().Interface(), true
+}
+
+func (r *ProtobufRegistry) typeOf(tag int32) (protoreflect.MessageType, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	mt, ok := r.types[tag]
+	return mt, ok
+}
+
+// ProtobufCodec encodes protobuf messages. A frame is an uvarint length of the rest,
+// followed by the uvarint type tag, the varint packet id and the message:
+//
+//	+---------+----------+--------+---------+
+//	| length  | type tag |   id   | message |
+//	+---------+----------+--------+---------+
+//
+// Messages are decoded into the types registered in Registry. Packets of unregistered
+// tags are read without Message, so that peers can send new types before they're
+// registered here, handlers can get the encoded message by Payload.
+type ProtobufCodec struct {
+	Registry *ProtobufRegistry
+
+	// MaxPacketSize is the max length of frame excluding length field, frames exceeding
+	// it are rejected with *FrameTooLargeError. Default is DefaultMaxPacketSize.
+	MaxPacketSize int
+}
+
+type ProtobufPacket struct {
+	ID int64
+
+	// Type is the tag of Message. If zero, the tag registered of Message type
+	// is used when written, which must be specified if the type is registered
+	// with multiple tags. If specified, it must be registered with Message type.
+	Type int32
+
+	// Message is nil if the packet read is tagged with an unregistered Type.
+	Message proto.Message
+
+	// Timestamp is the time packet decoded.
+	Timestamp time.Time
+
+	// raw is the encoded Message.
+	raw []byte
+}
+
+func (p ProtobufPacket) Id() int64       { return p.ID }
+func (p ProtobufPacket) Time() time.Time { return p.Timestamp }
+
+func (p ProtobufPacket) PacketType() int32 { return p.Type }
+
+// Payload returns the encoded Message.
+func (p ProtobufPacket) Payload() []byte {
+	if p.raw == nil && p.Message != nil {
+		data, _ := proto.Marshal(p.Message)
+		return data
+	}
+	return p.raw
+}
+
+// IsResponse reports whether ProtobufResponseFlag is set in Type.
+func (p ProtobufPacket) IsResponse() bool {
+	return p.Type&ProtobufResponseFlag != 0
+}
+
+// Response returns the response packet of p carrying the encoded message, which
+// has the same ID of p and ProtobufResponseFlag set in Type.
+func (p ProtobufPacket) Response(data []byte) sockit.Packet {
+	return ProtobufPacket{
+		ID:        p.ID,
+		Type:      p.Type | ProtobufResponseFlag,
+		Timestamp: time.Now(),
+		raw:       data,
+	}
+}
+
+// ProtobufCorrelator correlates ProtobufPacket request and response by ID,
+// packets with ProtobufResponseFlag set in Type are responses.
+type ProtobufCorrelator struct{}
+
+var _ sockit.Correlator = ProtobufCorrelator{}
+
+func (ProtobufCorrelator) RequestKey(p sockit.Packet) int64  { return p.Id() }
+func (ProtobufCorrelator) ResponseKey(p sockit.Packet) int64 { return p.Id() }
+
+func (ProtobufCorrelator) IsResponse(p sockit.Packet) bool {
+	pkt, ok := p.(ProtobufPacket)
+	return ok && pkt.Type&ProtobufResponseFlag != 0
+}
+
+func (c ProtobufCodec) framer() LengthFieldCodec {
+	return LengthFieldCodec{
+		LengthFieldLength: LengthFieldVarint,
+		MaxFrameSize:      c.MaxPacketSize,
+	}
+}
+
+func (c ProtobufCodec) Read(reader io.Reader) (sockit.Packet, error) {
+	if c.Registry == nil {
+		return nil, errors.New("protobuf codec registry is nil")
+	}
+
+	frame, err := c.framer().Read(reader)
+	if err != nil {
+		return nil, err
+	}
+	data := frame.(RawPacket).Data
+
+	tag, n := binary.Uvarint(data)
+	if n <= 0 || tag > 0xffffffff {
+		return nil, fmt.Errorf("%w: invalid type tag", ErrInvalidLength)
+	}
+	data = data[n:]
+
+	id, n := binary.Varint(data)
+	if n <= 0 {
+		return nil, fmt.Errorf("%w: invalid packet id", ErrInvalidLength)
+	}
+	data = data[n:]
+
+	pkt := ProtobufPacket{
+		ID:        id,
+		Type:      int32(uint32(tag)),
+		Timestamp: time.Now(),
+		raw:       data,
+	}
+	if m, ok := c.Registry.New(pkt.Type); ok {
+		if err := proto.Unmarshal(data, m); err != nil {
+			return nil, err
+		}
+		pkt.Message = m
+	}
+	return pkt, nil
+}
+
+func (c ProtobufCodec) Write(writer io.Writer, p sockit.Packet) error {
+	if c.Registry == nil {
+		return errors.New("protobuf codec registry is nil")
+	}
+	pkt, ok := p.(ProtobufPacket)
+	if !ok {
+		return fmt.Errorf("unknown packet type: %s", reflect.TypeOf(p).String())
+	}
+
+	data := pkt.raw
+	if pkt.Message != nil {
+		name := pkt.Message.ProtoReflect().Descriptor().FullName()
+		if pkt.Type == 0 {
+			tag, ok := c.Registry.Tag(pkt.Message)
+			if !ok {
+				if c.Registry.numTags(pkt.Message) > 1 {
+					return fmt.Errorf("%w: %s, Type must be specified", ErrAmbiguousType, name)
+				}
+				return fmt.Errorf("%w: %s", ErrUnregisteredType, name)
+			}
+			pkt.Type = tag
+		} else {
+			mt, ok := c.Registry.typeOf(pkt.Type)
+			if !ok {
+				return fmt.Errorf("%w: tag %d", ErrUnregisteredType, pkt.Type)
+			}
+			if want := mt.Descriptor().FullName(); want != name {
+				return fmt.Errorf("%w: %s, tag %d is %s", ErrTypeMismatch, name, pkt.Type, want)
+			}
+		}
+
+		var err error
+		if data, err = proto.Marshal(pkt.Message); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, 0, 2*binary.MaxVarintLen64+len(data))
+	buf = appendUvarint(buf, uint64(uint32(pkt.Type)))
+	buf = appendVarint(buf, pkt.ID)
+	buf = append(buf, data...)
+
+	return c.framer().Write(writer, RawPacket{Data: buf})
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
@@ -0,0 +1,153 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// testRegistry registers StringValue with tags 1 and 2, Int64Value with tag 3.
+func testRegistry(t *testing.T) *ProtobufRegistry {
+	t.Helper()
+	r := NewProtobufRegistry()
+	for tag, m := range map[int32]proto.Message{
+		1: &wrapperspb.StringValue{},
+		2: &wrapperspb.StringValue{},
+		3: &wrapperspb.Int64Value{},
+	} {
+		if err := r.Register(tag, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestProtobufRegistry(t *testing.T) {
+	r := testRegistry(t)
+
+	if err := r.Register(3, &wrapperspb.StringValue{}); err == nil {
+		t.Fatal("registered tag 3 twice")
+	}
+
+	if tag, ok := r.Tag(&wrapperspb.Int64Value{}); !ok || tag != 3 {
+		t.Fatalf("Tag(Int64Value) = %d, %v, want 3", tag, ok)
+	}
+	if _, ok := r.Tag(&wrapperspb.StringValue{}); ok {
+		t.Fatal("Tag of type registered with multiple tags succeeded")
+	}
+	if _, ok := r.Tag(&wrapperspb.BoolValue{}); ok {
+		t.Fatal("Tag of unregistered type succeeded")
+	}
+
+	for tag, want := range map[int32]proto.Message{1: &wrapperspb.StringValue{}, 2: &wrapperspb.StringValue{}, 3: &wrapperspb.Int64Value{}} {
+		m, ok := r.New(tag)
+		if !ok || m.ProtoReflect().Descriptor() != want.ProtoReflect().Descriptor() {
+			t.Fatalf("New(%d) = %T, %v", tag, m, ok)
+		}
+	}
+	if _, ok := r.New(4); ok {
+		t.Fatal("New of unregistered tag succeeded")
+	}
+}
+
+func TestProtobufCodecRoundTrip(t *testing.T) {
+	c := ProtobufCodec{Registry: testRegistry(t)}
+
+	packets := []ProtobufPacket{
+		{ID: 1, Message: wrapperspb.Int64(42)},                 // tag of type
+		{ID: -2, Type: 2, Message: wrapperspb.String("hello")}, // one of multiple tags
+		{ID: 3, Type: 1, Message: wrapperspb.String("")},
+	}
+	var buf bytes.Buffer
+	for _, p := range packets {
+		if err := c.Write(&buf, p); err != nil {
+			t.Fatalf("write packet %d: %v", p.ID, err)
+		}
+	}
+
+	wantTypes := []int32{3, 2, 1}
+	for i, want := range packets {
+		p, err := c.Read(&buf)
+		if err != nil {
+			t.Fatalf("read packet %d: %v", want.ID, err)
+		}
+		got := p.(ProtobufPacket)
+		if got.ID != want.ID || got.Type != wantTypes[i] || !proto.Equal(got.Message, want.Message) {
+			t.Fatalf("read %+v, want %+v of type %d", got, want, wantTypes[i])
+		}
+	}
+}
+
+func TestProtobufCodecUnregisteredTag(t *testing.T) {
+	writer := ProtobufCodec{Registry: testRegistry(t)}
+	reader := ProtobufCodec{Registry: NewProtobufRegistry()}
+	if err := reader.Registry.Register(3, &wrapperspb.Int64Value{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	for _, p := range []ProtobufPacket{
+		{ID: 1, Type: 2, Message: wrapperspb.String("hello")},
+		{ID: 2, Message: wrapperspb.Int64(42)},
+	} {
+		if err := writer.Write(&buf, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := reader.Read(&buf)
+	if err != nil {
+		t.Fatalf("read unregistered tag: %v", err)
+	}
+	got := p.(ProtobufPacket)
+	want, _ := proto.Marshal(wrapperspb.String("hello"))
+	if got.ID != 1 || got.Type != 2 || got.Message != nil || !bytes.Equal(got.Payload(), want) {
+		t.Fatalf("read %+v, payload %x", got, got.Payload())
+	}
+
+	// the stream is still readable
+	p, err = reader.Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.(ProtobufPacket); got.ID != 2 || !proto.Equal(got.Message, wrapperspb.Int64(42)) {
+		t.Fatalf("read %+v", got)
+	}
+}
+
+func TestProtobufCodecWriteErrors(t *testing.T) {
+	c := ProtobufCodec{Registry: testRegistry(t)}
+
+	tests := []struct {
+		name   string
+		packet ProtobufPacket
+		want   error
+	}{
+		{"ambiguous type", ProtobufPacket{Message: wrapperspb.String("a")}, ErrAmbiguousType},
+		{"unregistered type", ProtobufPacket{Message: wrapperspb.Bool(true)}, ErrUnregisteredType},
+		{"unregistered tag", ProtobufPacket{Type: 4, Message: wrapperspb.String("a")}, ErrUnregisteredType},
+		{"mismatched tag", ProtobufPacket{Type: 3, Message: wrapperspb.String("a")}, ErrTypeMismatch},
+	}
+	for _, tt := range tests {
+		if err := c.Write(&bytes.Buffer{}, tt.packet); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// responses carry encoded messages
+	var buf bytes.Buffer
+	req := ProtobufPacket{ID: 7, Type: 3}
+	if err := c.Write(&buf, req.Response([]byte("raw"))); err != nil {
+		t.Fatalf("write response: %v", err)
+	}
+	p, err := c.Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.(ProtobufPacket); got.ID != 7 || !got.IsResponse() || got.Message != nil || string(got.Payload()) != "raw" {
+		t.Fatalf("read response %+v", got)
+	}
+}
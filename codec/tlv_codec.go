@@ -1,27 +1,95 @@
 package codec
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"reflect"
-	"strconv"
 	"time"
 
 	"github.com/chenqinghe/sockit"
-	"github.com/sirupsen/logrus"
 )
 
 type TLVCodec struct {
 	KeepaliveType     int32
 	KeepaliveRespType int32
+
+	// Logger is used to log debug data. Default is sockit.DefaultLogger.
+	Logger sockit.Logger
+
+	// MaxPacketSize is the max length of packet data, packets exceeding it are
+	// rejected with *FrameTooLargeError. Default is DefaultMaxPacketSize.
+	MaxPacketSize uint64
+
+	// Version is the format used to write packets whose Version is neither TLVVersion1
+	// nor TLVVersion2. Default is TLVVersion1. Packets read keep the version of frame,
+	// so responses made from requests are written in the same version as requests.
+	//
+	// The version is not negotiated with peers: pushed packets, heartbeats and
+	// broadcasts are written in TLVVersion2 to every peer once it's set, which
+	// TLVVersion1 peers can't read. Set it only after all peers are upgraded.
+	//
+	// Unless Version is TLVVersion2 or MinVersion is set, frames other than
+	// TLVVersion2 are read as TLVVersion1, and their Version is kept as is both
+	// in packets read and in frames written, as applications may use it freely.
+	Version uint16
+
+	// MinVersion rejects frames of older versions with *UnsupportedVersionError,
+	// frames of unknown versions are rejected too once it's set.
+	MinVersion uint16
+
+	// Label is the magic label of TLVVersion2 frames. Default is TLVMagic.
+	Label uint16
+
+	// Resync enables recovering from corrupt frames. Instead of returning error,
+	// Read skips bytes until the next well-formed frame with the magic label,
+	// so frames of all versions must carry the magic label in this mode,
+	// and Write sets it.
+	// Frames of TLVVersion1 peers not in this mode have no label, they would be
+	// skipped silently, so Label must be set explicitly to confirm all peers
+	// write it unless Version is TLVVersion2, otherwise Read and Write return
+	// ErrResyncWithoutLabel.
+	// It works only if the reader is a sockit.BufferedReader, which is always
+	// true for connections of sockit.Server and sockit.Client.
+	Resync bool
+
+	// OnResync is called with the number of bytes skipped and the error of
+	// the first corrupt frame, before the next valid frame returned by Read.
+	OnResync func(skipped int, cause error)
 }
 
+const (
+	// TLVVersion1 frame is head, data and one byte additive checksum. Label is not checked.
+	// Frames with Version 0 are read as TLVVersion1 too.
+	TLVVersion1 uint16 = 1
+
+	// TLVVersion2 frame is head, head extensions, data and 4 bytes CRC32C of them.
+	// Label must be the magic label of codec. Extensions are present only if
+	// their flags set in the high byte of Version, see TLVFlagTrace.
+	TLVVersion2 uint16 = 2
+)
+
+// TLVMagic is the default magic label of TLVVersion2 frames.
+const TLVMagic uint16 = 0x534b // "SK"
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// DefaultMaxPacketSize is the default max length of packet data of TLVCodec.
+const DefaultMaxPacketSize = 16 << 20
+
+// chunkSize is the size of data allocated at most once when reading packet data,
+// so that the memory used grows with the data really received.
+const chunkSize = 64 << 10
+
 type TLVPacket struct {
 	PacketHead
 
 	isKeepAlive bool
+	traceParent string
 	Data        []byte
 }
 
@@ -36,6 +104,32 @@ type PacketHead struct {
 
 var headSize = binary.Size(&PacketHead{})
 
+// TLVResponseFlag is set in Type of response packet.
+const TLVResponseFlag int32 = 1 << 30
+
+// TLVFlagTrace is set in the high byte of Version of TLVVersion2 frames which carry
+// trace context. The trace context is a head extension placed between head and data:
+// one byte length followed by the traceparent, which is covered by the checksum.
+// TLVVersion1 frames don't carry trace context.
+const TLVFlagTrace uint16 = 1 << 8
+
+// tlvFlagsMask is the bits of Version used as flags of TLVVersion2 frames.
+const tlvFlagsMask uint16 = 0xff00
+
+// TLVCorrelator correlates TLVPacket request and response by ID,
+// packets with TLVResponseFlag set in Type are responses.
+type TLVCorrelator struct{}
+
+var _ sockit.Correlator = TLVCorrelator{}
+
+func (TLVCorrelator) RequestKey(p sockit.Packet) int64  { return p.Id() }
+func (TLVCorrelator) ResponseKey(p sockit.Packet) int64 { return p.Id() }
+
+func (TLVCorrelator) IsResponse(p sockit.Packet) bool {
+	pkt, ok := p.(TLVPacket)
+	return ok && pkt.Type&TLVResponseFlag != 0
+}
+
 func (p TLVPacket) Id() int64 {
 	return p.ID
 }
@@ -44,11 +138,148 @@ func (p TLVPacket) Time() time.Time {
 	return time.Unix(0, p.Timestamp*1e6)
 }
 
+func (p TLVPacket) PacketType() int32 { return p.Type }
+func (p TLVPacket) Payload() []byte   { return p.Data }
+
+// Response returns the response packet of p carrying data, which has the same ID and Version
+// of p and TLVResponseFlag set in Type.
+func (p TLVPacket) Response(data []byte) sockit.Packet {
+	head := p.PacketHead
+	head.Type |= TLVResponseFlag
+	head.Length = uint64(len(data))
+	return TLVPacket{PacketHead: head, Data: data}
+}
+
+// IsResponse reports whether TLVResponseFlag is set in Type.
+func (p TLVPacket) IsResponse() bool {
+	return p.Type&TLVResponseFlag != 0
+}
+
 func (p TLVPacket) IsKeepAlive() bool {
 	return p.isKeepAlive
 }
 
+func (p TLVPacket) TraceParent() string {
+	return p.traceParent
+}
+
+func (p TLVPacket) WithTraceParent(tp string) sockit.Packet {
+	p.traceParent = tp
+	return p
+}
+
 func (c TLVCodec) Read(reader io.Reader) (p sockit.Packet, err error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if br, ok := reader.(sockit.BufferedReader); ok && c.Resync {
+		return c.readResync(br)
+	}
+	return c.read(reader)
+}
+
+// readResync skips bytes until a valid frame read.
+func (c TLVCodec) readResync(br sockit.BufferedReader) (sockit.Packet, error) {
+	skipped := 0
+	var cause error
+	for {
+		p, n, err := c.peekFrame(br)
+		if err == nil {
+			if skipped > 0 {
+				c.logger().Warn("tlv stream resynchronized", "skipped", skipped, "cause", cause.Error())
+				if c.OnResync != nil {
+					c.OnResync(skipped, cause)
+				}
+			}
+			return p, nil
+		}
+		if !isCorrupt(err) {
+			return nil, err
+		}
+		if cause == nil {
+			cause = err
+		}
+		skipped += n
+	}
+}
+
+// peekFrame decodes the frame at the beginning of reader. If the frame is valid,
+// it's consumed. Otherwise, a corrupt error and the number of bytes skipped are returned.
+func (c TLVCodec) peekFrame(br sockit.BufferedReader) (sockit.Packet, int, error) {
+	headData, err := br.Peek(headSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var head PacketHead
+	if err := binary.Read(bytes.NewReader(headData), binary.BigEndian, &head); err != nil {
+		return nil, 0, err
+	}
+
+	if head.Label != c.label() {
+		return nil, 1, skipByte(br, &InvalidLabelError{Label: head.Label, Want: c.label()})
+	}
+	version, flags, err := c.readVersion(head)
+	if err != nil {
+		return nil, 1, skipByte(br, err)
+	}
+	if head.Length > c.maxPacketSize() {
+		return nil, 1, skipByte(br, &FrameTooLargeError{Size: head.Length, Limit: c.maxPacketSize()})
+	}
+
+	extSize := 0
+	if flags&TLVFlagTrace != 0 {
+		ext, err := br.Peek(headSize + 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		extSize = 1 + int(ext[headSize])
+	}
+
+	size := headSize + extSize + int(head.Length) + sumSize(version)
+	frame, err := br.Peek(size)
+	if errors.Is(err, bufio.ErrBufferFull) {
+		// the frame is larger than the buffer, it's consumed whether valid or not
+		p, err := c.read(br)
+		return p, size, err
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	p, err := c.read(bytes.NewReader(frame))
+	if err != nil {
+		return nil, 1, skipByte(br, err)
+	}
+	if _, err := io.CopyN(io.Discard, br, int64(size)); err != nil {
+		return nil, 0, err
+	}
+	return p, size, nil
+}
+
+// skipByte discards one byte from reader and returns corrupt if succeeded.
+func skipByte(br sockit.BufferedReader, corrupt error) error {
+	if _, err := br.ReadByte(); err != nil {
+		return err
+	}
+	return corrupt
+}
+
+// isCorrupt reports whether err is caused by corrupt frame data.
+func isCorrupt(err error) bool {
+	var (
+		fe *FrameTooLargeError
+		ve *UnsupportedVersionError
+		le *InvalidLabelError
+	)
+	return errors.Is(err, ErrInvalidChecksum) ||
+		errors.Is(err, errInvalidTraceContext) ||
+		errors.As(err, &fe) ||
+		errors.As(err, &ve) ||
+		errors.As(err, &le)
+}
+
+func (c TLVCodec) read(reader io.Reader) (sockit.Packet, error) {
 	var head PacketHead
 
 	headData := make([]byte, headSize)
@@ -60,27 +291,52 @@ func (c TLVCodec) Read(reader io.Reader) (p sockit.Packet, err error) {
 		return nil, err
 	}
 
-	logrus.WithFields(logrus.Fields{
-		"reqID": head.ID,
-	}).Debugln("packet data length:", strconv.Itoa(int(head.Length)))
+	version, flags, err := c.readVersion(head)
+	if err != nil {
+		return nil, err
+	}
+	if version == TLVVersion2 && head.Label != c.label() {
+		return nil, &InvalidLabelError{Label: head.Label, Want: c.label()}
+	}
+	if version == TLVVersion2 || c.strict() {
+		head.Version = version
+	}
+
+	c.logger().Debug("packet data length", "reqID", head.ID, "length", head.Length)
 
-	data := make([]byte, head.Length+1) // data and sum byte
+	if head.Length > c.maxPacketSize() {
+		return nil, &FrameTooLargeError{Size: head.Length, Limit: c.maxPacketSize()}
+	}
 
-	if _, err := io.ReadFull(reader, data); err != nil {
+	var tp string
+	if flags&TLVFlagTrace != 0 {
+		ext, err := readTraceExt(reader)
+		if err != nil {
+			return nil, err
+		}
+		tp = string(ext[1:])
+		headData = append(headData, ext...) // the extension is covered by checksum
+	}
+
+	data, err := readData(reader, head.Length+uint64(sumSize(version))) // data and checksum
+	if err != nil {
 		return nil, err
 	}
 
-	logrus.Debugln("packet data:", string(data[:len(data)-1]))
+	sum := data[head.Length:]
+	data = data[:head.Length]
+
+	c.logger().Debug("packet data", "reqID", head.ID, "data", string(data))
 
-	sum := (&checksum{}).Write(headData).Write(data[:len(data)-1]).Sum()
-	if sum != data[len(data)-1] {
-		return nil, fmt.Errorf("invalid checksum")
+	if !bytes.Equal(sum, appendSum(nil, version, headData, data)) {
+		return nil, ErrInvalidChecksum
 	}
 
 	return TLVPacket{
 		isKeepAlive: head.Type == c.KeepaliveType || head.Type == c.KeepaliveRespType,
 		PacketHead:  head,
-		Data:        data[:len(data)-1],
+		traceParent: tp,
+		Data:        data,
 	}, nil
 }
 
@@ -92,14 +348,45 @@ func (c TLVCodec) Write(writer io.Writer, p sockit.Packet) error {
 	if !ok {
 		return fmt.Errorf("unknown packet type: %s", reflect.TypeOf(p).String())
 	}
+	if err := c.validate(); err != nil {
+		return err
+	}
 	pkt.Timestamp = time.Now().UnixNano() / 1e6
 	pkt.Length = uint64(len(pkt.Data))
+	if pkt.Length > c.maxPacketSize() {
+		return &FrameTooLargeError{Size: pkt.Length, Limit: c.maxPacketSize()}
+	}
+
+	version, err := c.writeVersion(pkt.PacketHead)
+	if err != nil {
+		return err
+	}
+	if version == TLVVersion2 || c.strict() {
+		pkt.Version = version
+	}
+	if version == TLVVersion2 || c.Resync {
+		pkt.Label = c.label()
+	}
+
+	// trace context is carried by head extension of TLVVersion2 only,
+	// it's dropped for TLVVersion1 peers.
+	traceExt := version == TLVVersion2 && pkt.traceParent != ""
+	if traceExt {
+		if len(pkt.traceParent) > 255 {
+			return fmt.Errorf("trace context too long")
+		}
+		pkt.Version |= TLVFlagTrace
+	}
 
 	buf := bytes.NewBuffer(nil)
 
 	if err := binary.Write(buf, binary.BigEndian, pkt.PacketHead); err != nil {
 		return err
 	}
+	if traceExt {
+		buf.WriteByte(byte(len(pkt.traceParent)))
+		buf.WriteString(pkt.traceParent)
+	}
 
 	if _, err := writer.Write(buf.Bytes()); err != nil {
 		return err
@@ -109,15 +396,149 @@ func (c TLVCodec) Write(writer io.Writer, p sockit.Packet) error {
 		return err
 	}
 
-	sum := (&checksum{}).Write(buf.Bytes()).Write(pkt.Data).Sum()
-
-	if _, err := writer.Write([]byte{sum}); err != nil {
+	if _, err := writer.Write(appendSum(nil, version, buf.Bytes(), pkt.Data)); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// validate checks options of codec.
+func (c TLVCodec) validate() error {
+	if c.Resync && c.Version != TLVVersion2 && c.Label == 0 {
+		return ErrResyncWithoutLabel
+	}
+	return nil
+}
+
+// strict reports whether frames of unknown versions are rejected.
+func (c TLVCodec) strict() bool {
+	return c.Version == TLVVersion2 || c.MinVersion != 0
+}
+
+// isVersion2 reports whether v is the Version of a TLVVersion2 frame with known flags.
+func isVersion2(v uint16) bool {
+	return v&^tlvFlagsMask == TLVVersion2 && v&tlvFlagsMask&^TLVFlagTrace == 0
+}
+
+// readVersion returns the format version and the flags of frame. Flags are allowed
+// in TLVVersion2 frames only. In strict mode unknown versions and flags are rejected,
+// otherwise frames other than TLVVersion2 are read as TLVVersion1.
+func (c TLVCodec) readVersion(head PacketHead) (uint16, uint16, error) {
+	if !c.strict() {
+		if isVersion2(head.Version) {
+			return TLVVersion2, head.Version & tlvFlagsMask, nil
+		}
+		return TLVVersion1, 0, nil
+	}
+
+	version := head.Version &^ tlvFlagsMask
+	flags := head.Version & tlvFlagsMask
+	if version == 0 {
+		version = TLVVersion1
+	}
+	if version > TLVVersion2 || version < c.MinVersion ||
+		flags&^TLVFlagTrace != 0 || (flags != 0 && version != TLVVersion2) {
+		return 0, 0, &UnsupportedVersionError{Version: head.Version}
+	}
+	return version, flags, nil
+}
+
+// readTraceExt reads the trace context extension: one byte length and the traceparent.
+func readTraceExt(reader io.Reader) ([]byte, error) {
+	ext := make([]byte, 1, 256)
+	if _, err := io.ReadFull(reader, ext); err != nil {
+		return nil, err
+	}
+	if ext[0] == 0 {
+		return nil, errInvalidTraceContext
+	}
+	ext = ext[:1+int(ext[0])]
+	if _, err := io.ReadFull(reader, ext[1:]); err != nil {
+		return nil, err
+	}
+	return ext, nil
+}
+
+// writeVersion returns the format version to write the packet. Packets whose
+// Version would be read as TLVVersion2 are written in TLVVersion2, so are
+// flags of it recomputed.
+func (c TLVCodec) writeVersion(head PacketHead) (uint16, error) {
+	if isVersion2(head.Version) {
+		return TLVVersion2, nil
+	}
+	if head.Version == TLVVersion1 {
+		return TLVVersion1, nil
+	}
+
+	switch c.Version {
+	case 0:
+		return TLVVersion1, nil
+	case TLVVersion1, TLVVersion2:
+		return c.Version, nil
+	default:
+		return 0, &UnsupportedVersionError{Version: c.Version}
+	}
+}
+
+func (c TLVCodec) label() uint16 {
+	if c.Label == 0 {
+		return TLVMagic
+	}
+	return c.Label
+}
+
+func sumSize(version uint16) int {
+	if version == TLVVersion2 {
+		return crc32.Size
+	}
+	return 1
+}
+
+// appendSum appends the checksum of head and data in format of version to b.
+func appendSum(b []byte, version uint16, head, data []byte) []byte {
+	if version == TLVVersion2 {
+		sum := crc32.Update(crc32.Checksum(head, crc32c), crc32c, data)
+		return append(b, byte(sum>>24), byte(sum>>16), byte(sum>>8), byte(sum))
+	}
+	return append(b, (&checksum{}).Write(head).Write(data).Sum())
+}
+
+func (c TLVCodec) maxPacketSize() uint64 {
+	if c.MaxPacketSize == 0 {
+		return DefaultMaxPacketSize
+	}
+	return c.MaxPacketSize
+}
+
+// readData reads n bytes from reader. Large data is read chunk by chunk,
+// so a forged length doesn't allocate memory before data arrives.
+func readData(reader io.Reader, n uint64) ([]byte, error) {
+	if n <= chunkSize {
+		data := make([]byte, n)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, chunkSize))
+	if _, err := io.CopyN(buf, reader, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c TLVCodec) logger() sockit.Logger {
+	if c.Logger == nil {
+		return sockit.DefaultLogger
+	}
+	return c.Logger
+}
+
 type checksum struct {
 	sum uint32
 }
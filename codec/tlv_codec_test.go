@@ -0,0 +1,339 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/chenqinghe/sockit"
+)
+
+func encodeTLV(t *testing.T, c TLVCodec, p TLVPacket) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := c.Write(&buf, p); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestTLVCodecRoundTrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		codec       TLVCodec
+		wantVersion uint16
+		wantLabel   uint16
+		wantSize    int
+	}{
+		{"default", TLVCodec{}, 0, 0, headSize + 5 + 1},
+		{"v1", TLVCodec{Version: TLVVersion1}, 0, 0, headSize + 5 + 1},
+		{"v1 resync", TLVCodec{Version: TLVVersion1, Resync: true, Label: 0x1234}, 0, 0x1234, headSize + 5 + 1},
+		{"v2 resync", TLVCodec{Version: TLVVersion2, Resync: true}, TLVVersion2, TLVMagic, headSize + 5 + 4},
+		{"v1 min version", TLVCodec{Version: TLVVersion1, MinVersion: TLVVersion1}, TLVVersion1, 0, headSize + 5 + 1},
+		{"v2", TLVCodec{Version: TLVVersion2}, TLVVersion2, TLVMagic, headSize + 5 + 4},
+		{"v2 label", TLVCodec{Version: TLVVersion2, Label: 0x1234}, TLVVersion2, 0x1234, headSize + 5 + 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := encodeTLV(t, tt.codec, TLVPacket{
+				PacketHead: PacketHead{Type: 7, ID: 42},
+				Data:       []byte("hello"),
+			})
+			if len(frame) != tt.wantSize {
+				t.Fatalf("frame size = %d, want %d", len(frame), tt.wantSize)
+			}
+
+			p, err := tt.codec.Read(bytes.NewReader(frame))
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			got := p.(TLVPacket)
+			if got.Version != tt.wantVersion || got.Label != tt.wantLabel {
+				t.Errorf("version, label = %d, %#04x, want %d, %#04x", got.Version, got.Label, tt.wantVersion, tt.wantLabel)
+			}
+			if got.Type != 7 || got.ID != 42 || got.Length != 5 || string(got.Data) != "hello" {
+				t.Errorf("packet = %+v %q", got.PacketHead, got.Data)
+			}
+		})
+	}
+}
+
+func TestTLVCodecReadsV1WithV2Codec(t *testing.T) {
+	frame := encodeTLV(t, TLVCodec{Version: TLVVersion1}, TLVPacket{Data: []byte("v1")})
+
+	p, err := TLVCodec{Version: TLVVersion2}.Read(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	got := p.(TLVPacket)
+	if got.Version != TLVVersion1 || string(got.Data) != "v1" {
+		t.Fatalf("packet = %+v %q", got.PacketHead, got.Data)
+	}
+
+	// the response is written in the version of request
+	var buf bytes.Buffer
+	if err := (TLVCodec{Version: TLVVersion2}).Write(&buf, got.Response([]byte("ok"))); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if buf.Len() != headSize+2+1 {
+		t.Fatalf("response frame size = %d, want v1 frame", buf.Len())
+	}
+}
+
+func TestTLVCodecMinVersion(t *testing.T) {
+	c := TLVCodec{MinVersion: TLVVersion2}
+
+	for _, v := range []uint16{0, TLVVersion1} {
+		frame := encodeTLV(t, TLVCodec{}, TLVPacket{PacketHead: PacketHead{Version: v}})
+		_, err := c.Read(bytes.NewReader(frame))
+		var ve *UnsupportedVersionError
+		if !errors.As(err, &ve) {
+			t.Fatalf("version %d: err = %v, want *UnsupportedVersionError", v, err)
+		}
+	}
+
+	frame := encodeTLV(t, TLVCodec{Version: TLVVersion2}, TLVPacket{})
+	if _, err := c.Read(bytes.NewReader(frame)); err != nil {
+		t.Fatalf("v2 frame: %v", err)
+	}
+}
+
+func TestTLVCodecUnsupportedVersion(t *testing.T) {
+	if err := (TLVCodec{Version: 3}).Write(&bytes.Buffer{}, TLVPacket{}); err == nil {
+		t.Fatal("write with version 3 succeeded")
+	}
+
+	for _, c := range []TLVCodec{{MinVersion: TLVVersion1}, {Version: TLVVersion2}} {
+		for _, v := range []uint16{3, TLVVersion1 | TLVFlagTrace, TLVVersion2 | 0x200} {
+			frame := encodeTLV(t, TLVCodec{}, TLVPacket{PacketHead: PacketHead{Version: v}})
+			_, err := c.Read(bytes.NewReader(frame))
+			var ve *UnsupportedVersionError
+			if !errors.As(err, &ve) || ve.Version != v {
+				t.Fatalf("codec %+v, version %#04x: err = %v, want *UnsupportedVersionError", c, v, err)
+			}
+		}
+	}
+}
+
+func TestTLVCodecLegacyVersion(t *testing.T) {
+	// legacy peers may put anything in Version of TLVVersion1 frames
+	for _, v := range []uint16{0, 3, 7, 0x0101, TLVVersion2 | 0x200} {
+		frame := encodeTLV(t, TLVCodec{}, TLVPacket{PacketHead: PacketHead{Version: v}, Data: []byte("hi")})
+		if len(frame) != headSize+2+1 || binary.BigEndian.Uint16(frame[2:]) != v {
+			t.Fatalf("version %#04x: frame %x is not TLVVersion1 keeping Version", v, frame)
+		}
+
+		for _, c := range []TLVCodec{{}, {Version: TLVVersion1}} {
+			p, err := c.Read(bytes.NewReader(frame))
+			if err != nil {
+				t.Fatalf("version %#04x: read: %v", v, err)
+			}
+			if got := p.(TLVPacket); got.Version != v || string(got.Data) != "hi" {
+				t.Fatalf("version %#04x: packet = %+v %q", v, got.PacketHead, got.Data)
+			}
+		}
+	}
+
+	// unknown versions are rewritten in strict mode
+	frame := encodeTLV(t, TLVCodec{MinVersion: TLVVersion1}, TLVPacket{PacketHead: PacketHead{Version: 7}})
+	if v := binary.BigEndian.Uint16(frame[2:]); v != TLVVersion1 {
+		t.Fatalf("strict mode wrote version %#04x, want TLVVersion1", v)
+	}
+	frame = encodeTLV(t, TLVCodec{Version: TLVVersion2}, TLVPacket{PacketHead: PacketHead{Version: 7}})
+	if v := binary.BigEndian.Uint16(frame[2:]); v != TLVVersion2 {
+		t.Fatalf("v2 codec wrote version %#04x, want TLVVersion2", v)
+	}
+}
+
+func TestTLVCodecInvalidLabel(t *testing.T) {
+	frame := encodeTLV(t, TLVCodec{Version: TLVVersion2, Label: 0x1234}, TLVPacket{})
+	_, err := TLVCodec{}.Read(bytes.NewReader(frame))
+	var le *InvalidLabelError
+	if !errors.As(err, &le) || le.Label != 0x1234 || le.Want != TLVMagic {
+		t.Fatalf("err = %v, want *InvalidLabelError", err)
+	}
+}
+
+func TestTLVCodecCorruptChecksum(t *testing.T) {
+	for _, v := range []uint16{TLVVersion1, TLVVersion2} {
+		c := TLVCodec{Version: v}
+		frame := encodeTLV(t, c, TLVPacket{Data: []byte("hello")})
+
+		// corrupt the data and the checksum
+		for _, i := range []int{headSize, len(frame) - 1} {
+			corrupt := append([]byte(nil), frame...)
+			corrupt[i] ^= 0xff
+			if _, err := c.Read(bytes.NewReader(corrupt)); !errors.Is(err, ErrInvalidChecksum) {
+				t.Fatalf("version %d, byte %d: err = %v, want ErrInvalidChecksum", v, i, err)
+			}
+		}
+	}
+}
+
+func TestTLVCodecTraceContext(t *testing.T) {
+	const tp = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+	for _, v := range []uint16{TLVVersion1, TLVVersion2} {
+		c := TLVCodec{Version: v}
+		p := TLVPacket{PacketHead: PacketHead{Type: 7}, Data: []byte("hello")}
+		frame := encodeTLV(t, c, p.WithTraceParent(tp).(TLVPacket))
+
+		got, err := c.Read(bytes.NewReader(frame))
+		if err != nil {
+			t.Fatalf("version %d: read: %v", v, err)
+		}
+		pkt := got.(TLVPacket)
+		want, wantVersion := "", uint16(0)
+		if v == TLVVersion2 {
+			want, wantVersion = tp, TLVVersion2
+		}
+		if pkt.TraceParent() != want || pkt.Type != 7 || pkt.Version != wantVersion || string(pkt.Data) != "hello" {
+			t.Fatalf("version %d: packet = %+v %q, trace %q", v, pkt.PacketHead, pkt.Data, pkt.TraceParent())
+		}
+	}
+}
+
+// setLength sets the Length in head of frame.
+func setLength(frame []byte, n uint64) {
+	binary.BigEndian.PutUint64(frame[headSize-8:headSize], n)
+}
+
+func TestTLVCodecMaxPacketSize(t *testing.T) {
+	c := TLVCodec{MaxPacketSize: 10}
+
+	var fe *FrameTooLargeError
+	err := c.Write(&bytes.Buffer{}, TLVPacket{Data: make([]byte, 11)})
+	if !errors.As(err, &fe) || fe.Size != 11 || fe.Limit != 10 {
+		t.Fatalf("write: err = %v, want *FrameTooLargeError of size 11 limit 10", err)
+	}
+
+	if _, err := c.Read(bytes.NewReader(encodeTLV(t, c, TLVPacket{Data: make([]byte, 10)}))); err != nil {
+		t.Fatalf("read packet of max size: %v", err)
+	}
+
+	frame := encodeTLV(t, TLVCodec{}, TLVPacket{Data: make([]byte, 11)})
+	_, err = c.Read(bytes.NewReader(frame))
+	if !errors.As(err, &fe) || fe.Size != 11 || fe.Limit != 10 {
+		t.Fatalf("read: err = %v, want *FrameTooLargeError of size 11 limit 10", err)
+	}
+
+	// the length is checked before data read
+	setLength(frame, DefaultMaxPacketSize+1)
+	_, err = TLVCodec{}.Read(bytes.NewReader(frame[:headSize]))
+	if !errors.As(err, &fe) || fe.Size != DefaultMaxPacketSize+1 || fe.Limit != DefaultMaxPacketSize {
+		t.Fatalf("read with default limit: err = %v, want *FrameTooLargeError", err)
+	}
+}
+
+func TestTLVCodecLargeData(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 3*chunkSize/10+1)
+	frame := encodeTLV(t, TLVCodec{}, TLVPacket{Data: data})
+
+	p, err := TLVCodec{}.Read(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(p.(TLVPacket).Data, data) {
+		t.Fatal("data read mismatch")
+	}
+
+	// the body is shorter than the length
+	for _, n := range []int{headSize, headSize + 100, headSize + chunkSize + 100} {
+		if _, err := (TLVCodec{}).Read(bytes.NewReader(frame[:n])); err != io.ErrUnexpectedEOF {
+			t.Fatalf("read %d bytes of frame: err = %v, want io.ErrUnexpectedEOF", n, err)
+		}
+	}
+}
+
+func TestReadDataChunked(t *testing.T) {
+	const n = 2*chunkSize + 1
+
+	// a forged length doesn't allocate memory for the data not received
+	if _, err := readData(bytes.NewReader(make([]byte, 10)), 1<<40); err != io.ErrUnexpectedEOF {
+		t.Fatalf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+
+	data, err := readData(bytes.NewReader(make([]byte, n+5)), n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != n {
+		t.Fatalf("read %d bytes, want %d", len(data), n)
+	}
+}
+
+func TestTLVCodecResync(t *testing.T) {
+	// corrupt returns the frame with data corrupted
+	corrupt := func(frame []byte) []byte {
+		frame = append([]byte(nil), frame...)
+		frame[headSize] ^= 0xff
+		return frame
+	}
+
+	for _, c := range []TLVCodec{
+		{Version: TLVVersion1, Label: 0x1234, Resync: true, Logger: sockit.NopLogger{}},
+		{Version: TLVVersion2, Resync: true, Logger: sockit.NopLogger{}},
+		{Version: TLVVersion2, Resync: true, Logger: sockit.NopLogger{}, MaxPacketSize: 100},
+	} {
+		small := encodeTLV(t, c, TLVPacket{PacketHead: PacketHead{ID: 1}, Data: []byte("hello")})
+		large := encodeTLV(t, c, TLVPacket{PacketHead: PacketHead{ID: 1}, Data: bytes.Repeat([]byte("x"), 100)})
+		valid := encodeTLV(t, c, TLVPacket{PacketHead: PacketHead{ID: 2}, Data: []byte("ok")})
+		tooLarge := append([]byte(nil), small...)
+		setLength(tooLarge, c.maxPacketSize()+1)
+
+		tests := []struct {
+			name    string
+			garbage []byte
+		}{
+			{"leading garbage", []byte("garbage\x12")},
+			{"corrupt body", corrupt(small)},
+			{"corrupt frame larger than buffer", corrupt(large)},
+			{"length exceeds MaxPacketSize", tooLarge},
+		}
+		for _, tt := range tests {
+			var (
+				skipped = -1
+				cause   error
+			)
+			c := c
+			c.OnResync = func(n int, err error) { skipped, cause = n, err }
+
+			// the buffer is smaller than large frames
+			r := bufio.NewReaderSize(bytes.NewReader(append(append(append([]byte(nil), tt.garbage...), valid...), large...)), 64)
+			p, err := c.Read(r)
+			if err != nil {
+				t.Fatalf("version %d, %s: read: %v", c.Version, tt.name, err)
+			}
+			if p.Id() != 2 {
+				t.Fatalf("version %d, %s: read packet %d, want 2", c.Version, tt.name, p.Id())
+			}
+			if skipped != len(tt.garbage) || cause == nil {
+				t.Fatalf("version %d, %s: OnResync(%d, %v), want %d bytes skipped", c.Version, tt.name, skipped, cause, len(tt.garbage))
+			}
+
+			// a valid frame larger than buffer
+			skipped = -1
+			p, err = c.Read(r)
+			if err != nil {
+				t.Fatalf("version %d, %s: read large frame: %v", c.Version, tt.name, err)
+			}
+			if len(p.(TLVPacket).Data) != 100 || skipped != -1 {
+				t.Fatalf("version %d, %s: read large frame of %d bytes, %d bytes skipped", c.Version, tt.name, len(p.(TLVPacket).Data), skipped)
+			}
+		}
+	}
+}
+
+func TestTLVCodecResyncWithoutLabel(t *testing.T) {
+	c := TLVCodec{Resync: true}
+	if err := c.Write(&bytes.Buffer{}, TLVPacket{}); err != ErrResyncWithoutLabel {
+		t.Fatalf("write: err = %v, want ErrResyncWithoutLabel", err)
+	}
+	frame := encodeTLV(t, TLVCodec{}, TLVPacket{})
+	if _, err := c.Read(bufio.NewReader(bytes.NewReader(frame))); err != ErrResyncWithoutLabel {
+		t.Fatalf("read: err = %v, want ErrResyncWithoutLabel", err)
+	}
+}
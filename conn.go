@@ -1,12 +1,15 @@
 package sockit
 
 import (
-	"encoding/hex"
-	"fmt"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"io"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Conn interface {
@@ -23,31 +26,174 @@ type Conn interface {
 	Close() error
 }
 
+// BufferedReader is implemented by the reader which Conn passes to Codec.Read,
+// codecs can use it to peek or read delimited data efficiently.
+type BufferedReader interface {
+	io.Reader
+	io.ByteReader
+
+	// Peek returns the next n bytes without advancing the reader.
+	Peek(n int) ([]byte, error)
+
+	// ReadSlice reads until the first occurrence of delim in the input.
+	// The returned slice is only valid until the next read.
+	ReadSlice(delim byte) ([]byte, error)
+}
+
+// ConnOptions configures buffering of connections accepted by Server or dialed by Client.
+type ConnOptions struct {
+	// WriteBufferSize is the size of write buffer, buffered data is
+	// flushed when the buffer is full. Default is 4096.
+	WriteBufferSize int
+
+	// FlushInterval indicates how long written data may stay in buffer.
+	// If zero, data is flushed after every packet sent.
+	FlushInterval time.Duration
+
+	// ReadBufferSize is the size of read buffer. Default is 4096.
+	ReadBufferSize int
+}
+
+const (
+	defaultWriteBufferSize = 4096
+	defaultReadBufferSize  = 4096
+)
+
 type conn struct {
 	rdLock *sync.Mutex
-	wrLock *sync.Mutex
+	wrLock writeMutex
 
 	net.Conn
 
 	codec Codec
 
+	br            *bufio.Reader
+	bw            *bufio.Writer
+	flushInterval time.Duration
+	flushTimer    *time.Timer
+	wrErr         error // error of delayed flush
+
+	metrics MetricsCollector
+	tracer  Tracer
+	tap     *atomic.Value // tapHolder
+
+	nRead    int64 // accessed atomically
+	nWritten int64 // accessed atomically
+
 	closed int32
 }
 
 var _ Conn = (*conn)(nil)
 
-func newConn(c net.Conn, codec Codec) *conn {
-	return &conn{
-		rdLock: &sync.Mutex{},
-		wrLock: &sync.Mutex{},
-		Conn:   c,
-		codec:  codec,
-		closed: 0,
+func newConn(c net.Conn, codec Codec, opts *ConnOptions) *conn {
+	if opts == nil {
+		opts = &ConnOptions{}
+	}
+	wsize := opts.WriteBufferSize
+	if wsize <= 0 {
+		wsize = defaultWriteBufferSize
+	}
+	rsize := opts.ReadBufferSize
+	if rsize <= 0 {
+		rsize = defaultReadBufferSize
+	}
+
+	cc := &conn{
+		rdLock:        &sync.Mutex{},
+		wrLock:        newWriteMutex(),
+		Conn:          c,
+		codec:         codec,
+		flushInterval: opts.FlushInterval,
+		metrics:       nopMetrics{},
+		tracer:        nopTracer{},
+		tap:           &atomic.Value{},
+		closed:        0,
 	}
+	cc.br = bufio.NewReaderSize(rawReader{cc}, rsize)
+	cc.bw = bufio.NewWriterSize(rawWriter{cc}, wsize)
+
+	return cc
+}
+
+// writeMutex is a mutex supporting TryLock, since sync.Mutex.TryLock requires go1.18.
+type writeMutex chan struct{}
+
+func newWriteMutex() writeMutex {
+	return make(writeMutex, 1)
+}
+
+func (m writeMutex) Lock()   { m <- struct{}{} }
+func (m writeMutex) Unlock() { <-m }
+
+func (m writeMutex) TryLock() bool {
+	select {
+	case m <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// byteCounter is implemented by Conn which counts bytes read and written.
+type byteCounter interface {
+	bytesReceived() int64
+	bytesSent() int64
+}
+
+func (c *conn) bytesReceived() int64 { return atomic.LoadInt64(&c.nRead) }
+func (c *conn) bytesSent() int64     { return atomic.LoadInt64(&c.nWritten) }
+
+func (c *conn) setMetrics(mc MetricsCollector) {
+	if mc != nil {
+		c.metrics = mc
+	}
+}
+
+func (c *conn) setTracer(t Tracer) {
+	if t != nil {
+		c.tracer = t
+	}
+}
+
+func (c *conn) setTap(t Tap, sessionId int64) {
+	c.tap.Store(tapHolder{tap: t, sessionId: sessionId})
+}
+
+// currentTap returns Tap of the connection, nil if no Tap.
+func (c *conn) currentTap() (Tap, int64) {
+	h, _ := c.tap.Load().(tapHolder)
+	if h.tap == nil && DebugReadSend {
+		return HexdumpTap{}, h.sessionId
+	}
+	return h.tap, h.sessionId
+}
+
+func (c *conn) emitTap(t Tap, sessionId int64, dir Direction, frame []byte, p Packet) {
+	t.Tap(&TapEvent{
+		SessionId:  sessionId,
+		RemoteAddr: c.RemoteAddr(),
+		Direction:  dir,
+		Time:       time.Now(),
+		Frame:      frame,
+		Packet:     p,
+	})
 }
 
 func (c *conn) Close() error {
 	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		// make sure buffered data flushed in limited time
+		c.Conn.SetWriteDeadline(time.Now().Add(sendQueueFlushTimeout))
+
+		// if a write is in flight, the peer may be stalled,
+		// close the connection directly instead of waiting it.
+		if c.wrLock.TryLock() {
+			if c.flushTimer != nil {
+				c.flushTimer.Stop()
+			}
+			c.bw.Flush()
+			c.wrLock.Unlock()
+		}
+
 		return c.Conn.Close()
 	}
 	return nil
@@ -57,44 +203,151 @@ func (c *conn) SendPacket(p Packet) error {
 	c.wrLock.Lock()
 	defer c.wrLock.Unlock()
 
-	var wr io.Writer = c.Conn
-	if DebugReadSend {
-		wr = debugWriter{c.Conn}
+	if err := c.writePacketLocked(p); err != nil {
+		return err
+	}
+
+	if c.flushInterval > 0 {
+		if c.flushTimer == nil {
+			c.flushTimer = time.AfterFunc(c.flushInterval, c.delayedFlush)
+		}
+		return nil
+	}
+
+	return c.bw.Flush()
+}
+
+// sendPackets writes packets into buffer and flushes once, so that
+// multiple packets are sent with fewer syscalls.
+func (c *conn) sendPackets(packets ...Packet) error {
+	c.wrLock.Lock()
+	defer c.wrLock.Unlock()
+
+	for _, p := range packets {
+		if err := c.writePacketLocked(p); err != nil {
+			return err
+		}
+	}
+
+	return c.bw.Flush()
+}
+
+func (c *conn) writePacketLocked(p Packet) error {
+	if c.wrErr != nil {
+		err := c.wrErr
+		c.wrErr = nil
+		return err
 	}
 
-	return c.codec.Write(wr, p)
+	tap, sessionId := c.currentTap()
+	if tap == nil {
+		if err := c.codec.Write(c.bw, p); err != nil {
+			return err
+		}
+		c.metrics.PacketSent()
+		return nil
+	}
+
+	frame := bytes.NewBuffer(nil)
+	if err := c.codec.Write(io.MultiWriter(c.bw, frame), p); err != nil {
+		return err
+	}
+	c.metrics.PacketSent()
+	c.emitTap(tap, sessionId, DirectionOut, frame.Bytes(), p)
+	return nil
+}
+
+func (c *conn) delayedFlush() {
+	c.wrLock.Lock()
+	defer c.wrLock.Unlock()
+
+	c.flushTimer = nil
+	if err := c.bw.Flush(); err != nil {
+		c.wrErr = err
+	}
 }
 
 func (c *conn) ReadPacket() (Packet, error) {
 	c.rdLock.Lock()
 	defer c.rdLock.Unlock()
 
-	var rd io.Reader = c.Conn
-	if DebugReadSend {
-		rd = debugReader{c}
+	// wait until data arrived, so that the decode span excludes idle time
+	if _, err := c.br.Peek(1); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+
+	var rd BufferedReader = c.br
+	tap, sessionId := c.currentTap()
+	if tap != nil {
+		rd = &teeReader{br: c.br}
+	}
+
+	p, err := c.codec.Read(rd)
+	if err != nil {
+		if isDecodeError(err) {
+			c.metrics.DecodeError(err)
+		}
+		_, span := c.tracer.Start(context.Background(), spanDecode, start, "remoteAddr", c.RemoteAddr().String())
+		span.SetError(err)
+		span.End()
+		return nil, err
+	}
+	c.metrics.PacketReceived()
+
+	_, span := c.tracer.Start(extractTraceContext(c.tracer, p), spanDecode, start,
+		"remoteAddr", c.RemoteAddr().String(), "packetId", p.Id())
+	span.End()
+
+	if tap != nil {
+		c.emitTap(tap, sessionId, DirectionIn, rd.(*teeReader).buf, p)
 	}
-	return c.codec.Read(rd)
+
+	return p, nil
 }
 
-var DebugReadSend = false
+// isDecodeError reports whether err is caused by invalid data
+// rather than the connection.
+func isDecodeError(err error) bool {
+	var ne net.Error
+	return !errors.Is(err, io.EOF) &&
+		!errors.Is(err, io.ErrUnexpectedEOF) &&
+		!errors.Is(err, net.ErrClosed) &&
+		!errors.As(err, &ne)
+}
 
-type debugWriter struct {
-	w io.Writer
+var _ BufferedReader = (*bufio.Reader)(nil)
+
+// rawReader reads from the underlying connection.
+type rawReader struct {
+	c *conn
 }
 
-func (dw debugWriter) Write(p []byte) (int, error) {
-	n, err := dw.w.Write(p)
-	fmt.Println("write data:", p[:n])
-	fmt.Println("write data hex:", hex.EncodeToString(p[:n]))
+func (r rawReader) Read(p []byte) (int, error) {
+	n, err := r.c.Conn.Read(p)
+	if n > 0 {
+		atomic.AddInt64(&r.c.nRead, int64(n))
+		r.c.metrics.BytesReceived(n)
+	}
 	return n, err
 }
 
-type debugReader struct {
-	rd io.Reader
+// rawWriter writes to the underlying connection.
+type rawWriter struct {
+	c *conn
 }
 
-func (r debugReader) Read(p []byte) (int, error) {
-	n, err := r.rd.Read(p)
-	fmt.Println("read data:", p[:n])
+func (w rawWriter) Write(p []byte) (int, error) {
+	n, err := w.c.Conn.Write(p)
+	if n > 0 {
+		atomic.AddInt64(&w.c.nWritten, int64(n))
+		w.c.metrics.BytesSent(n)
+	}
 	return n, err
 }
+
+// DebugReadSend logs every packet read or sent in hexdump format
+// for connections without Tap.
+//
+// Deprecated: use Session.SetTap or NewManagerOptions.Tap with HexdumpTap instead.
+var DebugReadSend = false
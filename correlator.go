@@ -0,0 +1,49 @@
+package sockit
+
+// Correlator matches responses with requests sent by Session.SendRequest.
+type Correlator interface {
+	// RequestKey returns the key of a request packet.
+	RequestKey(p Packet) int64
+
+	// ResponseKey returns the key of a response packet,
+	// it should be same as the key of corresponding request.
+	ResponseKey(p Packet) int64
+
+	// IsResponse reports whether the packet is a response. Packets
+	// which are not response are always passed to Handler.
+	IsResponse(p Packet) bool
+}
+
+// ResponseMarker is implemented by packets which mark whether they are responses,
+// e.g. by a flag bit in packet type.
+type ResponseMarker interface {
+	IsResponse() bool
+}
+
+// MarkerCorrelator correlates request and response by Packet.Id, only packets
+// implementing ResponseMarker and marked as response are considered as responses.
+// It's the default Correlator.
+type MarkerCorrelator struct{}
+
+func (MarkerCorrelator) RequestKey(p Packet) int64  { return p.Id() }
+func (MarkerCorrelator) ResponseKey(p Packet) int64 { return p.Id() }
+
+func (MarkerCorrelator) IsResponse(p Packet) bool {
+	m, ok := p.(ResponseMarker)
+	return ok && m.IsResponse()
+}
+
+// IdCorrelator correlates request and response by Packet.Id, every packet
+// is considered as a possible response, so an unsolicited packet reusing the id
+// of a pending request is taken as its response. It should be used only if
+// the protocol has no response marker.
+type IdCorrelator struct{}
+
+func (IdCorrelator) RequestKey(p Packet) int64  { return p.Id() }
+func (IdCorrelator) ResponseKey(p Packet) int64 { return p.Id() }
+func (IdCorrelator) IsResponse(p Packet) bool   { return true }
+
+// correlatorProvider is implemented by ConnManager which configures Correlator of sessions.
+type correlatorProvider interface {
+	correlator() Correlator
+}
@@ -0,0 +1,200 @@
+package sockit
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// DispatchMode specify how received packets are dispatched to Handler.
+type DispatchMode int
+
+const (
+	// DispatchGoroutine starts a new goroutine for every packet, it's the default mode.
+	DispatchGoroutine DispatchMode = iota
+
+	// DispatchWorkerPool handles packets in a bounded worker pool shared by all sessions.
+	DispatchWorkerPool
+
+	// DispatchSerial handles packets of a session one by one in receiving order.
+	// If a key function is specified, packets with same key are handled in order,
+	// while packets with different keys may be handled in parallel.
+	DispatchSerial
+)
+
+const (
+	defaultWorkerPoolSize     = 64
+	defaultDispatchQueueSize  = 128
+	defaultDispatchConcurrent = 8
+)
+
+// dispatcher runs handle task of a session.
+// dispatch blocks when the queue is full, so that reading from the
+// connection is paused until the handlers catch up.
+// It returns false if the task is dropped because of the session closed.
+type dispatcher interface {
+	dispatch(s *Session, packet Packet, task func()) bool
+}
+
+// dispatcherProvider is implemented by ConnManager which supports
+// custom dispatch strategy.
+type dispatcherProvider interface {
+	newDispatcher(s *Session) dispatcher
+}
+
+type goroutineDispatcher struct{}
+
+func (goroutineDispatcher) dispatch(s *Session, packet Packet, task func()) bool {
+	go task()
+	return true
+}
+
+type workerPool struct {
+	tasks    chan func()
+	stopped  chan struct{}
+	stopOnce *sync.Once
+}
+
+func newWorkerPool(size, queueSize int) *workerPool {
+	if size <= 0 {
+		size = defaultWorkerPoolSize
+	}
+	if queueSize <= 0 {
+		queueSize = defaultDispatchQueueSize
+	}
+
+	p := &workerPool{
+		tasks:    make(chan func(), queueSize),
+		stopped:  make(chan struct{}),
+		stopOnce: &sync.Once{},
+	}
+	for i := 0; i < size; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	for {
+		select {
+		case task := <-p.tasks:
+			task()
+		case <-p.stopped:
+			return
+		}
+	}
+}
+
+func (p *workerPool) dispatch(s *Session, packet Packet, task func()) bool {
+	select {
+	case p.tasks <- task:
+		return true
+	case <-s.closed:
+		return false
+	case <-p.stopped:
+		return false
+	}
+}
+
+func (p *workerPool) stop() {
+	p.stopOnce.Do(func() { close(p.stopped) })
+}
+
+// serialDispatcher runs tasks of a session one by one in order. The goroutine
+// running tasks is started on demand and exits when the queue is drained,
+// so idle sessions don't hold goroutines.
+type serialDispatcher struct {
+	tasks   chan func()
+	closed  <-chan struct{} // closed when the session closed
+	running int32           // accessed atomically
+}
+
+func newSerialDispatcher(closed <-chan struct{}, queueSize int) *serialDispatcher {
+	if queueSize <= 0 {
+		queueSize = defaultDispatchQueueSize
+	}
+
+	return &serialDispatcher{
+		tasks:  make(chan func(), queueSize),
+		closed: closed,
+	}
+}
+
+func (d *serialDispatcher) run() {
+	for {
+		select {
+		case <-d.closed:
+			return
+		default:
+		}
+
+		select {
+		case task := <-d.tasks:
+			task()
+			continue
+		default:
+		}
+
+		atomic.StoreInt32(&d.running, 0)
+		// a task queued before running cleared didn't start a new goroutine
+		if len(d.tasks) == 0 || !atomic.CompareAndSwapInt32(&d.running, 0, 1) {
+			return
+		}
+	}
+}
+
+func (d *serialDispatcher) dispatch(s *Session, packet Packet, task func()) bool {
+	select {
+	case d.tasks <- task:
+	case <-d.closed:
+		return false
+	}
+	if atomic.CompareAndSwapInt32(&d.running, 0, 1) {
+		go d.run()
+	}
+	return true
+}
+
+// keyedDispatcher dispatches tasks of a session to several serialDispatchers
+// by the key of packet, packets with same key always go to the same one.
+// The serialDispatchers are created on first use.
+type keyedDispatcher struct {
+	keyFunc   func(p Packet) string
+	queueSize int
+	closed    <-chan struct{}
+
+	mu    *sync.Mutex
+	lanes []*serialDispatcher
+}
+
+func newKeyedDispatcher(closed <-chan struct{}, keyFunc func(p Packet) string, concurrent, queueSize int) *keyedDispatcher {
+	if concurrent <= 0 {
+		concurrent = defaultDispatchConcurrent
+	}
+
+	return &keyedDispatcher{
+		keyFunc:   keyFunc,
+		queueSize: queueSize,
+		closed:    closed,
+		mu:        &sync.Mutex{},
+		lanes:     make([]*serialDispatcher, concurrent),
+	}
+}
+
+func (d *keyedDispatcher) lane(key string) *serialDispatcher {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	i := h.Sum32() % uint32(len(d.lanes))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.lanes[i] == nil {
+		d.lanes[i] = newSerialDispatcher(d.closed, d.queueSize)
+	}
+	return d.lanes[i]
+}
+
+func (d *keyedDispatcher) dispatch(s *Session, packet Packet, task func()) bool {
+	return d.lane(d.keyFunc(packet)).dispatch(s, packet, task)
+}
@@ -0,0 +1,231 @@
+package sockit
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyedDispatchOrder(t *testing.T) {
+	const n = 300
+	keys := []string{"a", "b", "c", "d"}
+
+	var (
+		mu      sync.Mutex
+		handled = make(map[string][]int64)
+		count   int
+	)
+	mgr := NewManager(HandlerFunc(func(p Packet, s *Session) {
+		pkt := p.(testPacket)
+		time.Sleep(time.Duration(pkt.ID%3) * 100 * time.Microsecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		handled[pkt.Data] = append(handled[pkt.Data], pkt.ID)
+		count++
+	}), &NewManagerOptions{
+		DispatchMode:       DispatchSerial,
+		DispatchKeyFunc:    func(p Packet) string { return p.(testPacket).Data },
+		DispatchConcurrent: 2,
+	})
+	defer mgr.Close()
+
+	c := newFakeConn()
+	if _, err := mgr.StoreConn(c); err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < n; i++ {
+		c.in <- testPacket{ID: i, Data: keys[i%int64(len(keys))]}
+	}
+	waitFor(t, "packets handled", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return count == n
+	})
+
+	for key, ids := range handled {
+		for i := 1; i < len(ids); i++ {
+			if ids[i] < ids[i-1] {
+				t.Fatalf("packets of key %s handled out of order: %v", key, ids)
+			}
+		}
+	}
+}
+
+func TestKeyedDispatchParallel(t *testing.T) {
+	bHandled := make(chan struct{})
+	mgr := NewManager(HandlerFunc(func(p Packet, s *Session) {
+		if p.(testPacket).Data == "a" {
+			<-bHandled // blocks the lane of a until b handled
+		} else {
+			close(bHandled)
+		}
+	}), &NewManagerOptions{
+		DispatchMode:       DispatchSerial,
+		DispatchKeyFunc:    func(p Packet) string { return p.(testPacket).Data },
+		DispatchConcurrent: 8,
+	})
+	defer mgr.Close()
+
+	d := newKeyedDispatcher(make(chan struct{}), nil, 8, 0)
+	if d.lane("a") == d.lane("b") {
+		t.Fatal("keys a and b are in the same lane")
+	}
+
+	c := newFakeConn()
+	if _, err := mgr.StoreConn(c); err != nil {
+		t.Fatal(err)
+	}
+	c.in <- testPacket{ID: 1, Data: "a"}
+	c.in <- testPacket{ID: 2, Data: "b"}
+	select {
+	case <-bHandled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("packet of key b blocked by key a")
+	}
+}
+
+func TestSerialDispatchIdleGoroutines(t *testing.T) {
+	const sessions = 50
+
+	handled := make(chan Packet, sessions)
+	for _, opts := range []*NewManagerOptions{
+		{DispatchMode: DispatchSerial},
+		{DispatchMode: DispatchSerial, DispatchKeyFunc: func(p Packet) string { return p.(testPacket).Data }},
+	} {
+		mgr := NewManager(chanHandler(handled), opts)
+		before := runtime.NumGoroutine()
+
+		for i := 0; i < sessions; i++ {
+			c := newFakeConn()
+			if _, err := mgr.StoreConn(c); err != nil {
+				t.Fatal(err)
+			}
+			c.in <- testPacket{ID: int64(i), Data: "key"}
+			recvPacket(t, handled)
+		}
+
+		// each idle session has a reading and a writing goroutine only
+		waitFor(t, "dispatch goroutines exit", func() bool {
+			return runtime.NumGoroutine()-before <= 2*sessions
+		})
+		mgr.Close()
+	}
+}
+
+func TestWorkerPoolBound(t *testing.T) {
+	const poolSize = 2
+
+	var (
+		mu               sync.Mutex
+		running, maxRuns int
+	)
+	release := make(chan struct{})
+	handled := make(chan Packet, 8)
+	mgr := NewManager(HandlerFunc(func(p Packet, s *Session) {
+		mu.Lock()
+		running++
+		if running > maxRuns {
+			maxRuns = running
+		}
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		handled <- p
+	}), &NewManagerOptions{
+		DispatchMode:   DispatchWorkerPool,
+		WorkerPoolSize: poolSize,
+	})
+	defer mgr.Close()
+
+	// the pool is shared by all sessions
+	for i := 0; i < 2; i++ {
+		c := newFakeConn()
+		if _, err := mgr.StoreConn(c); err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 3; j++ {
+			c.in <- testPacket{ID: int64(i*3 + j)}
+		}
+	}
+
+	waitFor(t, "workers busy", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return running == poolSize
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	close(release)
+	for i := 0; i < 6; i++ {
+		recvPacket(t, handled)
+	}
+	if maxRuns != poolSize {
+		t.Fatalf("%d handlers ran at the same time, want %d", maxRuns, poolSize)
+	}
+}
+
+func TestSerialDispatchOrder(t *testing.T) {
+	const n = 100
+
+	handled := make(chan Packet, n)
+	mgr := NewManager(HandlerFunc(func(p Packet, s *Session) {
+		time.Sleep(time.Duration(p.Id()%3) * 100 * time.Microsecond)
+		handled <- p
+	}), &NewManagerOptions{DispatchMode: DispatchSerial})
+	defer mgr.Close()
+
+	c := newFakeConn()
+	if _, err := mgr.StoreConn(c); err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < n; i++ {
+		c.in <- testPacket{ID: i}
+	}
+	for i := int64(0); i < n; i++ {
+		if p := recvPacket(t, handled); p.Id() != i {
+			t.Fatalf("handled packet %d, want %d", p.Id(), i)
+		}
+	}
+}
+
+func TestDispatchBackpressure(t *testing.T) {
+	for _, opts := range []*NewManagerOptions{
+		{DispatchMode: DispatchSerial, DispatchQueueSize: 1},
+		{DispatchMode: DispatchWorkerPool, WorkerPoolSize: 1, DispatchQueueSize: 1},
+	} {
+		started, release := make(chan Packet, 4), make(chan struct{})
+		mgr := NewManager(blockingHandler(started, release), opts)
+
+		c := newFakeConn()
+		if _, err := mgr.StoreConn(c); err != nil {
+			t.Fatal(err)
+		}
+		c.in <- testPacket{ID: 1}
+		recvPacket(t, started)
+		c.in <- testPacket{ID: 2} // queued
+		c.in <- testPacket{ID: 3} // read, waiting for room in queue
+
+		select {
+		case c.in <- testPacket{ID: 4}:
+			t.Fatalf("mode %d: packet read while dispatch queue full", opts.DispatchMode)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		close(release)
+		select {
+		case c.in <- testPacket{ID: 4}:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("mode %d: reading not resumed", opts.DispatchMode)
+		}
+		for i := 0; i < 3; i++ {
+			recvPacket(t, started)
+		}
+		mgr.Close()
+	}
+}
@@ -6,6 +6,7 @@ require (
 	github.com/golang-jwt/jwt v3.2.1+incompatible
 	github.com/gorilla/websocket v1.4.2
 	github.com/sirupsen/logrus v1.8.1
+	google.golang.org/protobuf v1.31.0
 )
 
 require golang.org/x/sys v0.0.0-20221010170243-090e33056c14 // indirect
@@ -0,0 +1,180 @@
+package sockit
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// groups stores group memberships of sessions.
+type groups struct {
+	mu       *sync.RWMutex
+	members  map[string]map[int64]*Session // group -> sessions
+	sessions map[int64]map[string]struct{} // session id -> groups
+}
+
+func newGroups() *groups {
+	return &groups{
+		mu:       &sync.RWMutex{},
+		members:  make(map[string]map[int64]*Session),
+		sessions: make(map[int64]map[string]struct{}),
+	}
+}
+
+func (g *groups) join(s *Session, group string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.members[group] == nil {
+		g.members[group] = make(map[int64]*Session)
+	}
+	g.members[group][s.Id()] = s
+
+	if g.sessions[s.Id()] == nil {
+		g.sessions[s.Id()] = make(map[string]struct{})
+	}
+	g.sessions[s.Id()][group] = struct{}{}
+}
+
+func (g *groups) leave(id int64, group string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.leaveLocked(id, group)
+}
+
+func (g *groups) leaveLocked(id int64, group string) {
+	delete(g.members[group], id)
+	if len(g.members[group]) == 0 {
+		delete(g.members, group)
+	}
+	delete(g.sessions[id], group)
+	if len(g.sessions[id]) == 0 {
+		delete(g.sessions, id)
+	}
+}
+
+// leaveAll removes the session from all groups.
+func (g *groups) leaveAll(id int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for group := range g.sessions[id] {
+		g.leaveLocked(id, group)
+	}
+}
+
+func (g *groups) membersOf(group string) []*Session {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(g.members[group]))
+	for _, s := range g.members[group] {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+func (g *groups) groupsOf(id int64) []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	names := make([]string, 0, len(g.sessions[id]))
+	for name := range g.sessions[id] {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (g *groups) all() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	names := make([]string, 0, len(g.members))
+	for name := range g.members {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Join adds the session into group, the session will leave all groups when closed.
+func (m *Manager) Join(sessionId int64, group string) error {
+	s, ok := m.FindSession(sessionId)
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	m.groups.join(s, group)
+
+	// the session may be removed concurrently
+	if _, ok := m.FindSession(sessionId); !ok {
+		m.groups.leaveAll(sessionId)
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// Leave removes the session from group.
+func (m *Manager) Leave(sessionId int64, group string) {
+	m.groups.leave(sessionId, group)
+}
+
+// GroupMembers returns all sessions in the group.
+func (m *Manager) GroupMembers(group string) []*Session {
+	return m.groups.membersOf(group)
+}
+
+// SessionGroups returns all groups the session joined.
+func (m *Manager) SessionGroups(sessionId int64) []string {
+	return m.groups.groupsOf(sessionId)
+}
+
+// Groups returns names of all groups which have members.
+func (m *Manager) Groups() []string {
+	return m.groups.all()
+}
+
+// Broadcast sends the packet to all sessions in the group except sessions
+// specified by exclude. Packets are sent by Session.TrySendPacketAsync, so a slow
+// member doesn't block others, the packet is discarded for members whose send
+// queue is full. If Backplane specified, the packet is also sent to members on
+// other nodes, exclude only applies to local sessions.
+// It returns the first error of sending.
+func (m *Manager) Broadcast(group string, p Packet, exclude ...int64) error {
+	err := m.broadcastLocal(group, p, exclude)
+	if m.bp != nil {
+		if rerr := m.routeToGroup(group, p); rerr != nil {
+			m.log.Error("route packet to group error", "group", group, "error", rerr.Error())
+			if err == nil {
+				err = rerr
+			}
+		}
+	}
+	return err
+}
+
+func (m *Manager) broadcastLocal(group string, p Packet, exclude []int64) error {
+	var firstErr error
+	for _, s := range m.groups.membersOf(group) {
+		if containsId(exclude, s.Id()) {
+			continue
+		}
+		if err := s.TrySendPacketAsync(p); err != nil {
+			s.Logger().Error("broadcast packet error", "group", group, "error", err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func containsId(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
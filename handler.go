@@ -6,6 +6,13 @@ type Handler interface {
 	Handle(packet Packet, s *Session)
 }
 
+// HandlerFunc is an adapter to allow the use of ordinary functions as Handler.
+type HandlerFunc func(packet Packet, s *Session)
+
+func (f HandlerFunc) Handle(packet Packet, s *Session) {
+	f(packet, s)
+}
+
 type WsHandler interface {
 	Handle(c *websocket.Conn)
 }
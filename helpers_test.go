@@ -0,0 +1,220 @@
+package sockit
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	DefaultLogger = NopLogger{}
+	os.Exit(m.Run())
+}
+
+// testPacket is the packet of testCodec.
+type testPacket struct {
+	ID   int64
+	Resp bool
+	Type int32
+	Data string
+}
+
+func (p testPacket) Id() int64         { return p.ID }
+func (p testPacket) Time() time.Time   { return time.Time{} }
+func (p testPacket) IsResponse() bool  { return p.Resp }
+func (p testPacket) PacketType() int32 { return p.Type }
+
+// testCodec frames testPacket as 8 bytes id, 1 byte response flag,
+// 4 bytes type, 4 bytes length of data and data.
+type testCodec struct{}
+
+func (testCodec) Read(r io.Reader) (Packet, error) {
+	var head [17]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(head[13:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return testPacket{
+		ID:   int64(binary.BigEndian.Uint64(head[:8])),
+		Resp: head[8] == 1,
+		Type: int32(binary.BigEndian.Uint32(head[9:])),
+		Data: string(data),
+	}, nil
+}
+
+func (testCodec) Write(w io.Writer, p Packet) error {
+	pkt := p.(testPacket)
+	var head [17]byte
+	binary.BigEndian.PutUint64(head[:8], uint64(pkt.ID))
+	if pkt.Resp {
+		head[8] = 1
+	}
+	binary.BigEndian.PutUint32(head[9:], uint32(pkt.Type))
+	binary.BigEndian.PutUint32(head[13:], uint32(len(pkt.Data)))
+	if _, err := w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, pkt.Data)
+	return err
+}
+
+// startServer serves mgr on a loopback address, the server is closed when test ends.
+func startServer(t *testing.T, mgr ConnManager) (*Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(mgr, testCodec{})
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return srv, l.Addr().String()
+}
+
+// recvPacket receives a packet from ch or fails the test after timeout.
+func recvPacket(t *testing.T, ch <-chan Packet) Packet {
+	t.Helper()
+	select {
+	case p := <-ch:
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting packet")
+		return nil
+	}
+}
+
+// waitFor waits until cond is true or fails the test after timeout.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// chanHandler passes packets received to a channel.
+func chanHandler(ch chan<- Packet) Handler {
+	return HandlerFunc(func(p Packet, s *Session) { ch <- p })
+}
+
+// constPolicy retries to reconnect forever at a constant interval.
+type constPolicy time.Duration
+
+func (p constPolicy) Retry() bool        { return true }
+func (p constPolicy) Timer() *time.Timer { return time.NewTimer(time.Duration(p)) }
+
+// fakeConn is a Conn whose packets read are fed by in, and packets sent are recorded.
+type fakeConn struct {
+	in chan Packet
+
+	// gate blocks SendPacket until it's closed, if not nil.
+	gate chan struct{}
+
+	// writing receives packets when SendPacket called, if not nil.
+	writing chan Packet
+
+	// deadline overrides the write deadline set, if not zero.
+	deadline time.Duration
+
+	expired    chan struct{} // closed when write deadline exceeded
+	expireOnce *sync.Once
+
+	mu      *sync.Mutex
+	sent    []Packet
+	sendErr error
+
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		in:         make(chan Packet),
+		expired:    make(chan struct{}),
+		expireOnce: &sync.Once{},
+		mu:         &sync.Mutex{},
+		closed:     make(chan struct{}),
+		closeOnce:  &sync.Once{},
+	}
+}
+
+var fakeAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+func (c *fakeConn) LocalAddr() net.Addr  { return fakeAddr }
+func (c *fakeConn) RemoteAddr() net.Addr { return fakeAddr }
+
+func (c *fakeConn) ReadPacket() (Packet, error) {
+	select {
+	case p := <-c.in:
+		return p, nil
+	case <-c.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (c *fakeConn) SendPacket(p Packet) error {
+	if c.writing != nil {
+		c.writing <- p
+	}
+	if c.gate != nil {
+		select {
+		case <-c.gate:
+		case <-c.closed:
+			return net.ErrClosed
+		case <-c.expired:
+			return os.ErrDeadlineExceeded
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sendErr != nil {
+		return c.sendErr
+	}
+	c.sent = append(c.sent, p)
+	return nil
+}
+
+func (c *fakeConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *fakeConn) SetWriteDeadline(t time.Time) error {
+	d := time.Until(t)
+	if c.deadline != 0 {
+		d = c.deadline
+	}
+	time.AfterFunc(d, func() {
+		c.expireOnce.Do(func() { close(c.expired) })
+	})
+	return nil
+}
+
+// Sent returns packets sent.
+func (c *fakeConn) Sent() []Packet {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Packet(nil), c.sent...)
+}
+
+func (c *fakeConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
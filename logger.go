@@ -0,0 +1,73 @@
+package sockit
+
+import "github.com/sirupsen/logrus"
+
+// Logger is a structured logger. args are alternating keys and values,
+// same as log/slog, e.g. logger.Info("session closed", "sessionId", 1).
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+
+	// With returns a Logger which includes args in each output.
+	With(args ...interface{}) Logger
+}
+
+// DefaultLogger is used when no Logger specified, it writes to the standard logger of logrus.
+var DefaultLogger Logger = NewLogrusLogger(logrus.StandardLogger())
+
+// NopLogger discards all logs.
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, args ...interface{}) {}
+func (NopLogger) Info(msg string, args ...interface{})  {}
+func (NopLogger) Warn(msg string, args ...interface{})  {}
+func (NopLogger) Error(msg string, args ...interface{}) {}
+func (l NopLogger) With(args ...interface{}) Logger     { return l }
+
+type logrusLogger struct {
+	l logrus.FieldLogger
+}
+
+// NewLogrusLogger adapts a logrus logger to Logger.
+func NewLogrusLogger(l logrus.FieldLogger) Logger {
+	return logrusLogger{l: l}
+}
+
+func (l logrusLogger) Debug(msg string, args ...interface{}) { l.with(args).Debug(msg) }
+func (l logrusLogger) Info(msg string, args ...interface{})  { l.with(args).Info(msg) }
+func (l logrusLogger) Warn(msg string, args ...interface{})  { l.with(args).Warn(msg) }
+func (l logrusLogger) Error(msg string, args ...interface{}) { l.with(args).Error(msg) }
+
+func (l logrusLogger) With(args ...interface{}) Logger {
+	return logrusLogger{l: l.with(args)}
+}
+
+func (l logrusLogger) with(args []interface{}) logrus.FieldLogger {
+	if len(args) == 0 {
+		return l.l
+	}
+	return l.l.WithFields(argsToFields(args))
+}
+
+// argsToFields converts alternating keys and values to logrus.Fields,
+// a key without value is stored under "!BADKEY" like log/slog does.
+func argsToFields(args []interface{}) logrus.Fields {
+	fields := make(logrus.Fields, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		key, ok := args[i].(string)
+		if !ok || i+1 == len(args) {
+			fields["!BADKEY"] = args[i]
+			i--
+			continue
+		}
+		fields[key] = args[i+1]
+	}
+	return fields
+}
+
+// loggerProvider is implemented by ConnManager which configures Logger of sessions.
+type loggerProvider interface {
+	logger() Logger
+}
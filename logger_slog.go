@@ -0,0 +1,31 @@
+//go:build go1.21
+// +build go1.21
+
+package sockit
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger adapts a log/slog logger to Logger.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l: l}
+}
+
+func (l slogLogger) Debug(msg string, args ...interface{}) { l.log(slog.LevelDebug, msg, args) }
+func (l slogLogger) Info(msg string, args ...interface{})  { l.log(slog.LevelInfo, msg, args) }
+func (l slogLogger) Warn(msg string, args ...interface{})  { l.log(slog.LevelWarn, msg, args) }
+func (l slogLogger) Error(msg string, args ...interface{}) { l.log(slog.LevelError, msg, args) }
+
+func (l slogLogger) With(args ...interface{}) Logger {
+	return slogLogger{l: l.l.With(args...)}
+}
+
+func (l slogLogger) log(level slog.Level, msg string, args []interface{}) {
+	l.l.Log(context.Background(), level, msg, args...)
+}
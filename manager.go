@@ -4,9 +4,8 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/sirupsen/logrus"
 )
 
 // Manager is a default implementation of ConnManager interface.
@@ -17,23 +16,40 @@ type Manager struct {
 	// conns stores all sessions
 	conns map[int64]*Session
 
-	// users stores all login user ids
+	// users stores sessions of all login user ids
 	ulock *sync.RWMutex
-	users map[string]*Session
+	users map[string]map[int64]*Session
+
+	groups *groups
 
 	authenticator Authenticator
 	handler       Handler
+	log           Logger
 
 	opts            *NewManagerOptions
 	keepaliveTicker *time.Ticker
 	keepaliveCtx    context.Context
 	cancelKeepalive context.CancelFunc
 
+	pool *workerPool
+
+	tapValue *atomic.Value // tapHolder
+
+	node      string
+	bp        Backplane
+	bpTasks   *backplaneTasks
+	leaveOnce *sync.Once
+
 	closed    chan struct{}
+	closeOnce *sync.Once
 	closeDone chan struct{}
 }
 
-var _ ConnManager = (*Manager)(nil)
+var (
+	_ GracefulConnManager = (*Manager)(nil)
+	_ UserConnManager     = (*Manager)(nil)
+	_ GroupConnManager    = (*Manager)(nil)
+)
 
 type NewManagerOptions struct {
 	// Authenticator is used to verify accepted connection is valid
@@ -41,6 +57,7 @@ type NewManagerOptions struct {
 
 	// ExclusiveUser indicates that only one client connect permitted with same user id
 	// if user with same id login again, the previous one will be kicked out.
+	// Otherwise, a user may have multiple sessions at the same time.
 	ExclusiveUser bool
 
 	// KeepaliveTick indicates time duration between every connection checking
@@ -54,6 +71,78 @@ type NewManagerOptions struct {
 
 	// AfterSessionClosed specify a post-hook of session closed
 	AfterSessionClosed func(s *Session)
+
+	// GoingAwayPacket specify a factory of packet which will be sent to
+	// every session when Shutdown is called. nil means nothing sent.
+	GoingAwayPacket func(s *Session) Packet
+
+	// Middlewares wrap the handler, the first one is the outermost.
+	Middlewares []Middleware
+
+	// OnHandlerPanic is called when handler panics, recovered is the value
+	// returned by recover and stack is the goroutine stack trace.
+	// If nil, the panic will be logged.
+	OnHandlerPanic func(s *Session, packet Packet, recovered interface{}, stack []byte)
+
+	// CloseSessionOnPanic indicates whether to close the session
+	// whose handler panics.
+	CloseSessionOnPanic bool
+
+	// DispatchMode specify how packets are dispatched to handler.
+	DispatchMode DispatchMode
+
+	// WorkerPoolSize is the number of workers in DispatchWorkerPool mode.
+	WorkerPoolSize int
+
+	// DispatchQueueSize is the capacity of the pending packets queue in
+	// DispatchWorkerPool and DispatchSerial mode. Reading from connection
+	// will be blocked when the queue is full.
+	DispatchQueueSize int
+
+	// DispatchKeyFunc returns the ordering key of packet in DispatchSerial mode.
+	// Packets with same key are handled in order, others may be handled in parallel.
+	// If nil, all packets of a session are handled in order.
+	DispatchKeyFunc func(p Packet) string
+
+	// DispatchConcurrent is the max number of packets of a session handled
+	// in parallel when DispatchKeyFunc is specified.
+	DispatchConcurrent int
+
+	// SendQueueSize is the capacity of send queue of each session used by SendPacketAsync.
+	SendQueueSize int
+
+	// SendQueuePolicy specify what to do when the send queue is full.
+	SendQueuePolicy OverflowPolicy
+
+	// Correlator matches responses with requests of sessions.
+	// Default is MarkerCorrelator.
+	Correlator Correlator
+
+	// Metrics collects metrics of sessions, connections and handlers.
+	Metrics MetricsCollector
+
+	// Logger is used to log events of manager and sessions. Default is DefaultLogger.
+	Logger Logger
+
+	// Tracer traces sending requests, decoding and handling packets.
+	Tracer Tracer
+
+	// Tap observes packets of all sessions, it can be changed by Manager.SetTap.
+	Tap Tap
+
+	// KickPacket specify a factory of packet which will be sent to
+	// sessions kicked by KickUser. nil means nothing sent.
+	KickPacket func(s *Session, reason string) Packet
+
+	// Backplane routes SendToUser and Broadcast to sessions owned by other nodes.
+	Backplane Backplane
+
+	// BackplaneCodec encodes packets routed by Backplane, it's required if Backplane specified.
+	BackplaneCodec Codec
+
+	// NodeId is the id of this manager in Backplane, it must be unique in the cluster.
+	// Default is generated by hostname, pid and time.
+	NodeId string
 }
 
 func NewManager(handler Handler, opts *NewManagerOptions) *Manager {
@@ -64,12 +153,29 @@ func NewManager(handler Handler, opts *NewManagerOptions) *Manager {
 		mu:            &sync.RWMutex{},
 		conns:         make(map[int64]*Session),
 		ulock:         &sync.RWMutex{},
-		users:         make(map[string]*Session),
-		handler:       handler,
+		users:         make(map[string]map[int64]*Session),
+		groups:        newGroups(),
+		handler:       Chain(handler, opts.Middlewares...),
+		log:           opts.Logger,
 		authenticator: opts.Authenticator,
 		opts:          opts,
 		closed:        make(chan struct{}),
+		tapValue:      &atomic.Value{},
+		closeOnce:     &sync.Once{},
 		closeDone:     make(chan struct{}),
+		node:          opts.NodeId,
+		leaveOnce:     &sync.Once{},
+	}
+	m.tapValue.Store(tapHolder{tap: opts.Tap})
+	if m.log == nil {
+		m.log = DefaultLogger
+	}
+	if m.node == "" {
+		m.node = defaultNodeId()
+	}
+	m.joinBackplane()
+	if opts.DispatchMode == DispatchWorkerPool {
+		m.pool = newWorkerPool(opts.WorkerPoolSize, opts.DispatchQueueSize)
 	}
 	if opts.KeepaliveTick != 0 {
 		m.keepaliveTicker = time.NewTicker(opts.KeepaliveTick)
@@ -79,33 +185,35 @@ func NewManager(handler Handler, opts *NewManagerOptions) *Manager {
 }
 
 func (m *Manager) StoreConn(c Conn) (*Session, error) {
+	if ms, ok := c.(metricsSetter); ok {
+		ms.setMetrics(m.opts.Metrics)
+	}
+	if ts, ok := c.(tracerSetter); ok {
+		ts.setTracer(m.opts.Tracer)
+	}
+
 	var user User
 	var err error
 	if m.authenticator != nil {
 		user, err = m.authenticator.Auth(c)
 		if err != nil {
-			logrus.Info("user auth failed: " + err.Error())
+			m.log.Info("user auth failed", "remoteAddr", c.RemoteAddr().String(), "error", err.Error())
 			c.Close()
 			return nil, err
 		}
 		if !user.Valid() {
-			logrus.WithFields(logrus.Fields{
-				"remoteAddr": c.RemoteAddr().String(),
-			}).Debugln("user not valid")
+			m.log.Debug("user not valid", "remoteAddr", c.RemoteAddr().String())
 			c.Close()
 			return nil, errors.New("invalid user")
 		}
 
 		if m.opts.ExclusiveUser {
-			m.ulock.Lock()
-			if s, ok := m.users[user.Id()]; ok {
-				s.Close()
-				logrus.WithFields(logrus.Fields{
-					"remoteAddr": c.RemoteAddr().String(),
-				}).Infoln("same user login again, close previous")
+			if sessions, ok := m.FindSessionByUser(user.Id()); ok {
+				m.log.Info("same user login again, close previous", "remoteAddr", c.RemoteAddr().String(), "userId", user.Id())
+				for _, s := range sessions {
+					m.RemoveSession(s.Id())
+				}
 			}
-			delete(m.users, user.Id())
-			m.ulock.Unlock()
 		}
 	}
 
@@ -117,11 +225,21 @@ func (m *Manager) StoreConn(c Conn) (*Session, error) {
 
 	if user != nil {
 		m.ulock.Lock()
-		m.users[user.Id()] = sess
+		if m.users[user.Id()] == nil {
+			m.users[user.Id()] = make(map[int64]*Session)
+			if m.bp != nil {
+				m.publishPresence(user.Id(), true)
+			}
+		}
+		m.users[user.Id()][sess.Id()] = sess
 		m.ulock.Unlock()
 	}
 
-	logrus.Debug("accept a new connection, remote addr:" + c.RemoteAddr().String())
+	if m.opts.Metrics != nil {
+		m.opts.Metrics.SessionOpened(sess)
+	}
+
+	sess.Logger().Debug("accept a new connection")
 
 	if m.opts.OnSessionCreated != nil {
 		m.opts.OnSessionCreated(sess)
@@ -135,13 +253,93 @@ func (m *Manager) SetAuthenticator(authenticator Authenticator) {
 }
 
 func (m *Manager) Close() error {
-	close(m.closed)
+	m.closeOnce.Do(func() { close(m.closed) })
 	m.RangeSession(func(s *Session) {
 		m.RemoveSession(s.Id())
 	})
+	if m.pool != nil {
+		m.pool.stop()
+	}
+	m.leaveOnce.Do(m.leaveBackplane)
+	return nil
+}
+
+// shutdownPollInterval is how often Shutdown checks whether sessions are idle.
+var shutdownPollInterval = 100 * time.Millisecond
+
+// Shutdown gracefully closes all sessions. It first sends the GoingAwayPacket
+// (if configured) to every session, then closes each session as soon as
+// it has no running handler and no pending request, and returns after packets
+// queued by SendPacketAsync are flushed and connections closed. If ctx expires before
+// all sessions are closed, the remaining are force closed and ctx.Err() is returned.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.closeOnce.Do(func() { close(m.closed) })
+
+	var sessions []*Session
+	m.RangeSession(func(s *Session) {
+		sessions = append(sessions, s)
+	})
+
+	if m.opts.GoingAwayPacket != nil {
+		// packets are queued without blocking, so a stalled peer doesn't delay
+		// others, they are flushed in limited time when sessions closed.
+		for _, s := range sessions {
+			if ctx.Err() != nil {
+				break
+			}
+			if p := m.opts.GoingAwayPacket(s); p != nil {
+				if err := s.TrySendPacketAsync(p); err != nil {
+					s.Logger().Error("send going away packet error", "error", err.Error())
+				}
+			}
+		}
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if m.closeIdleSessions() {
+			if err := m.Close(); err != nil {
+				return err
+			}
+			return waitFlushed(ctx, sessions)
+		}
+		select {
+		case <-ctx.Done():
+			m.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitFlushed waits until send queues of sessions are flushed and connections closed.
+func waitFlushed(ctx context.Context, sessions []*Session) error {
+	for _, s := range sessions {
+		select {
+		case <-s.sendQueue.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 	return nil
 }
 
+// closeIdleSessions closes all idle sessions and reports whether
+// there is no session left.
+func (m *Manager) closeIdleSessions() bool {
+	m.RangeSession(func(s *Session) {
+		if s.idle() {
+			m.RemoveSession(s.Id())
+		}
+	})
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.conns) == 0
+}
+
 func (m *Manager) RemoveSession(id int64) error {
 	m.mu.Lock()
 	sess, ok := m.conns[id]
@@ -154,14 +352,26 @@ func (m *Manager) RemoveSession(id int64) error {
 
 	if sess.User() != nil {
 		m.ulock.Lock()
-		delete(m.users, sess.User().Id())
+		delete(m.users[sess.User().Id()], id)
+		if sessions, ok := m.users[sess.User().Id()]; ok && len(sessions) == 0 {
+			delete(m.users, sess.User().Id())
+			if m.bp != nil {
+				m.publishPresence(sess.User().Id(), false)
+			}
+		}
 		m.ulock.Unlock()
 	}
 
+	m.groups.leaveAll(id)
+
 	if m.opts.BeforeSessionClosed != nil {
 		m.opts.BeforeSessionClosed(sess)
 	}
 
+	if m.opts.Metrics != nil {
+		m.opts.Metrics.SessionClosed(sess)
+	}
+
 	if err := sess.close(); err != nil {
 		return err
 	}
@@ -173,6 +383,66 @@ func (m *Manager) RemoveSession(id int64) error {
 	return nil
 }
 
+func (m *Manager) newDispatcher(s *Session) dispatcher {
+	switch m.opts.DispatchMode {
+	case DispatchWorkerPool:
+		return m.pool
+	case DispatchSerial:
+		if m.opts.DispatchKeyFunc != nil {
+			return newKeyedDispatcher(s.closed, m.opts.DispatchKeyFunc, m.opts.DispatchConcurrent, m.opts.DispatchQueueSize)
+		}
+		return newSerialDispatcher(s.closed, m.opts.DispatchQueueSize)
+	default:
+		return goroutineDispatcher{}
+	}
+}
+
+func (m *Manager) sendQueueOptions() (int, OverflowPolicy) {
+	return m.opts.SendQueueSize, m.opts.SendQueuePolicy
+}
+
+func (m *Manager) correlator() Correlator {
+	return m.opts.Correlator
+}
+
+func (m *Manager) metrics() MetricsCollector {
+	return m.opts.Metrics
+}
+
+func (m *Manager) tap() Tap {
+	return m.tapValue.Load().(tapHolder).tap
+}
+
+// SetTap sets Tap of all current and future sessions, nil means disable it.
+func (m *Manager) SetTap(t Tap) {
+	m.tapValue.Store(tapHolder{tap: t})
+	m.RangeSession(func(s *Session) {
+		s.SetTap(t)
+	})
+}
+
+func (m *Manager) tracer() Tracer {
+	return m.opts.Tracer
+}
+
+func (m *Manager) logger() Logger {
+	return m.log
+}
+
+func (m *Manager) handlePanic(s *Session, packet Packet, recovered interface{}, stack []byte) {
+	if m.opts.OnHandlerPanic != nil {
+		m.opts.OnHandlerPanic(s, packet, recovered, stack)
+	} else {
+		s.Logger().Error("handler panic", "panic", recovered, "stack", string(stack))
+	}
+
+	if m.opts.CloseSessionOnPanic {
+		if err := m.RemoveSession(s.Id()); err != nil {
+			s.Logger().Error("remove session error", "error", err.Error())
+		}
+	}
+}
+
 func (m *Manager) FindSession(id int64) (*Session, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -181,10 +451,106 @@ func (m *Manager) FindSession(id int64) (*Session, bool) {
 	return sess, ok
 }
 
-func (m *Manager) RangeSession(fn func(s *Session)) {
-	sessions := make([]*Session, 0, len(m.conns))
+// FindSessionByUser returns all sessions of the user.
+func (m *Manager) FindSessionByUser(id string) ([]*Session, bool) {
+	m.ulock.RLock()
+	defer m.ulock.RUnlock()
+
+	sessions := make([]*Session, 0, len(m.users[id]))
+	for _, s := range m.users[id] {
+		sessions = append(sessions, s)
+	}
+	return sessions, len(sessions) > 0
+}
+
+// SendToUser sends the packet to all sessions of the user, including sessions owned by
+// other nodes if Backplane specified. ErrUserNotFound is returned if the user has
+// no session, otherwise the first error of sending is returned.
+//
+// If the user has sessions on this node, the packet is routed to other nodes
+// asynchronously, and errors of routing are only logged.
+func (m *Manager) SendToUser(id string, p Packet) error {
+	found, err := m.sendToLocalUser(id, p)
+	if m.bp == nil {
+		if !found {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	data, derr := m.encodeBackplanePacket(p)
+	if derr != nil {
+		m.log.Error("encode backplane packet error", "userId", id, "error", derr.Error())
+		if !found {
+			return derr
+		}
+		return err
+	}
+
+	if found {
+		m.bpTasks.push(func() {
+			if _, _, err := m.routeToUser(id, data); err != nil {
+				m.log.Error("route packet to user error", "userId", id, "error", err.Error())
+			}
+		})
+		return err
+	}
+
+	found, sent, err := m.routeToUser(id, data)
+	if err != nil {
+		m.log.Error("route packet to user error", "userId", id, "error", err.Error())
+		if !sent {
+			return err
+		}
+	}
+	if !found {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (m *Manager) sendToLocalUser(id string, p Packet) (bool, error) {
+	sessions, ok := m.FindSessionByUser(id)
+	if !ok {
+		return false, nil
+	}
+
+	var firstErr error
+	for _, s := range sessions {
+		if err := s.SendPacket(p); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return true, firstErr
+}
+
+// KickUser closes all sessions of the user. If KickPacket specified,
+// it's sent to the sessions before closed.
+func (m *Manager) KickUser(id string, reason string) error {
+	sessions, ok := m.FindSessionByUser(id)
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	for _, s := range sessions {
+		s.Logger().Info("kick user", "userId", id, "reason", reason)
+		if m.opts.KickPacket != nil {
+			if p := m.opts.KickPacket(s, reason); p != nil {
+				if err := s.SendPacket(p); err != nil {
+					s.Logger().Error("send kick packet error", "error", err.Error())
+				}
+			}
+		}
+		if err := m.RemoveSession(s.Id()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+func (m *Manager) RangeSession(fn func(s *Session)) {
 	m.mu.RLock()
+	sessions := make([]*Session, 0, len(m.conns))
 	for _, v := range m.conns {
 		sessions = append(sessions, v)
 	}
@@ -219,11 +585,8 @@ func (m *Manager) SetKeepAlive(b bool) {
 				case <-m.keepaliveTicker.C:
 					now := time.Now()
 					m.RangeSession(func(s *Session) {
-						if now.Sub(s.lastPackTs) > m.opts.KeepaliveTick {
-							logrus.WithFields(logrus.Fields{
-								"remoteAddr": s.RemoteAddr().String(),
-								"sessionID":  s.Id(),
-							}).Debug("session keepalive timeout")
+						if now.Sub(s.LastPacketTime()) > m.opts.KeepaliveTick {
+							s.Logger().Debug("session keepalive timeout")
 							m.RemoveSession(s.Id())
 						}
 					})
@@ -0,0 +1,145 @@
+package sockit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShutdownWaitsGoingAwayFlushed(t *testing.T) {
+	mgr := NewManager(HandlerFunc(func(Packet, *Session) {}), &NewManagerOptions{
+		GoingAwayPacket: func(s *Session) Packet { return testPacket{ID: 99} },
+	})
+	c := newFakeConn()
+	c.gate = make(chan struct{}) // the peer is slow
+	if _, err := mgr.StoreConn(c); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- mgr.Shutdown(context.Background()) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v before going away packet flushed", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(c.gate)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting Shutdown")
+	}
+	if sent := c.Sent(); len(sent) != 1 || sent[0].Id() != 99 {
+		t.Fatalf("sent %v, want going away packet", sent)
+	}
+	if !c.isClosed() {
+		t.Fatal("connection not closed")
+	}
+}
+
+// blockingHandler notifies started when handling a packet, and returns after release closed.
+func blockingHandler(started chan<- Packet, release <-chan struct{}) Handler {
+	return HandlerFunc(func(p Packet, s *Session) {
+		started <- p
+		<-release
+	})
+}
+
+func TestShutdownWaitsHandler(t *testing.T) {
+	started, release := make(chan Packet, 1), make(chan struct{})
+	mgr := NewManager(blockingHandler(started, release), &NewManagerOptions{
+		GoingAwayPacket: func(s *Session) Packet { return testPacket{ID: 99} },
+	})
+	c := newFakeConn()
+	s, err := mgr.StoreConn(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.in <- testPacket{ID: 1}
+	recvPacket(t, started)
+
+	done := make(chan error, 1)
+	go func() { done <- mgr.Shutdown(context.Background()) }()
+
+	waitFor(t, "going away packet", func() bool { return len(c.Sent()) == 1 })
+	if p := c.Sent()[0]; p.Id() != 99 {
+		t.Fatalf("sent packet %d, want going away packet", p.Id())
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v while handler running", err)
+	case <-time.After(2 * shutdownPollInterval):
+	}
+	if _, ok := mgr.FindSession(s.Id()); !ok || c.isClosed() {
+		t.Fatal("session closed while handler running")
+	}
+
+	close(release)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting Shutdown")
+	}
+	if !c.isClosed() {
+		t.Fatal("connection not closed")
+	}
+}
+
+func TestShutdownForceClose(t *testing.T) {
+	started, release := make(chan Packet, 1), make(chan struct{})
+	defer close(release)
+	mgr := NewManager(blockingHandler(started, release), nil)
+	c := newFakeConn()
+	s, err := mgr.StoreConn(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.in <- testPacket{ID: 1}
+	recvPacket(t, started)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := mgr.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Shutdown took %v after ctx expired", d)
+	}
+	if _, ok := mgr.FindSession(s.Id()); ok {
+		t.Fatal("session not removed")
+	}
+	waitFor(t, "connection closed", c.isClosed)
+}
+
+func TestShutdownCanceled(t *testing.T) {
+	started, release := make(chan Packet, 1), make(chan struct{})
+	defer close(release)
+	mgr := NewManager(blockingHandler(started, release), &NewManagerOptions{
+		GoingAwayPacket: func(s *Session) Packet { return testPacket{ID: 99} },
+	})
+	c := newFakeConn()
+	if _, err := mgr.StoreConn(c); err != nil {
+		t.Fatal(err)
+	}
+	c.in <- testPacket{ID: 1}
+	recvPacket(t, started)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := mgr.Shutdown(ctx); err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if sent := c.Sent(); len(sent) != 0 {
+		t.Fatalf("sent %v after ctx canceled", sent)
+	}
+	waitFor(t, "connection closed", c.isClosed)
+}
@@ -0,0 +1,52 @@
+package sockit
+
+import "time"
+
+// MetricsCollector collects runtime metrics of sessions, connections and handlers.
+// Methods may be called concurrently.
+type MetricsCollector interface {
+	// SessionOpened is called when a session is stored into Manager.
+	SessionOpened(s *Session)
+
+	// SessionClosed is called when a session is removed from Manager.
+	SessionClosed(s *Session)
+
+	// PacketReceived is called when a packet is decoded from connection.
+	PacketReceived()
+
+	// PacketSent is called when a packet is encoded to connection.
+	PacketSent()
+
+	// BytesReceived is called with the number of bytes read from connection.
+	BytesReceived(n int)
+
+	// BytesSent is called with the number of bytes written to connection.
+	BytesSent(n int)
+
+	// DecodeError is called when Codec fails to decode a packet.
+	DecodeError(err error)
+
+	// HandleDuration is called with the time spent by Handler for a packet.
+	HandleDuration(d time.Duration)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) SessionOpened(s *Session)       {}
+func (nopMetrics) SessionClosed(s *Session)       {}
+func (nopMetrics) PacketReceived()                {}
+func (nopMetrics) PacketSent()                    {}
+func (nopMetrics) BytesReceived(n int)            {}
+func (nopMetrics) BytesSent(n int)                {}
+func (nopMetrics) DecodeError(err error)          {}
+func (nopMetrics) HandleDuration(d time.Duration) {}
+
+// metricsProvider is implemented by ConnManager which collects metrics.
+type metricsProvider interface {
+	metrics() MetricsCollector
+}
+
+// metricsSetter is implemented by Conn which reports metrics.
+type metricsSetter interface {
+	setMetrics(mc MetricsCollector)
+}
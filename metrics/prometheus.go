@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenqinghe/sockit"
+)
+
+// DefaultBuckets is the default handler latency buckets in seconds.
+var DefaultBuckets = []float64{.0005, .001, .005, .01, .05, .1, .5, 1, 5}
+
+// PrometheusCollector is a sockit.MetricsCollector which exposes the metrics
+// in Prometheus text exposition format, it can be served by http server directly:
+//
+//	collector := metrics.NewPrometheusCollector("sockit", nil)
+//	mgr := sockit.NewManager(handler, &sockit.NewManagerOptions{Metrics: collector})
+//	http.Handle("/metrics", collector)
+type PrometheusCollector struct {
+	namespace string
+
+	sessionsActive  int64
+	sessionsTotal   int64
+	packetsReceived int64
+	packetsSent     int64
+	bytesReceived   int64
+	bytesSent       int64
+	decodeErrors    int64
+
+	buckets      []float64
+	bucketCounts []int64 // counts of each bucket, not cumulative
+	handleCount  int64
+	handleSumNs  int64
+}
+
+var (
+	_ sockit.MetricsCollector = (*PrometheusCollector)(nil)
+	_ http.Handler            = (*PrometheusCollector)(nil)
+)
+
+// NewPrometheusCollector creates a collector, metric names are prefixed with namespace.
+// If buckets is nil, DefaultBuckets is used. buckets must be in increasing order.
+func NewPrometheusCollector(namespace string, buckets []float64) *PrometheusCollector {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return &PrometheusCollector{
+		namespace:    namespace,
+		buckets:      buckets,
+		bucketCounts: make([]int64, len(buckets)+1), // the last one is +Inf
+	}
+}
+
+func (c *PrometheusCollector) SessionOpened(s *sockit.Session) {
+	atomic.AddInt64(&c.sessionsActive, 1)
+	atomic.AddInt64(&c.sessionsTotal, 1)
+}
+
+func (c *PrometheusCollector) SessionClosed(s *sockit.Session) {
+	atomic.AddInt64(&c.sessionsActive, -1)
+}
+
+func (c *PrometheusCollector) PacketReceived()       { atomic.AddInt64(&c.packetsReceived, 1) }
+func (c *PrometheusCollector) PacketSent()           { atomic.AddInt64(&c.packetsSent, 1) }
+func (c *PrometheusCollector) BytesReceived(n int)   { atomic.AddInt64(&c.bytesReceived, int64(n)) }
+func (c *PrometheusCollector) BytesSent(n int)       { atomic.AddInt64(&c.bytesSent, int64(n)) }
+func (c *PrometheusCollector) DecodeError(err error) { atomic.AddInt64(&c.decodeErrors, 1) }
+
+func (c *PrometheusCollector) HandleDuration(d time.Duration) {
+	sec := d.Seconds()
+	i := 0
+	for i < len(c.buckets) && sec > c.buckets[i] {
+		i++
+	}
+	atomic.AddInt64(&c.bucketCounts[i], 1)
+	atomic.AddInt64(&c.handleCount, 1)
+	atomic.AddInt64(&c.handleSumNs, int64(d))
+}
+
+func (c *PrometheusCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WriteTo(w)
+}
+
+// WriteTo writes all metrics in Prometheus text exposition format.
+func (c *PrometheusCollector) WriteTo(w io.Writer) (int64, error) {
+	ew := &errWriter{w: w}
+
+	c.writeMetric(ew, "gauge", "sessions_active", "Number of active sessions.", atomic.LoadInt64(&c.sessionsActive))
+	c.writeMetric(ew, "counter", "sessions_total", "Total number of sessions opened.", atomic.LoadInt64(&c.sessionsTotal))
+	c.writeMetric(ew, "counter", "packets_received_total", "Total number of packets received.", atomic.LoadInt64(&c.packetsReceived))
+	c.writeMetric(ew, "counter", "packets_sent_total", "Total number of packets sent.", atomic.LoadInt64(&c.packetsSent))
+	c.writeMetric(ew, "counter", "bytes_received_total", "Total number of bytes received.", atomic.LoadInt64(&c.bytesReceived))
+	c.writeMetric(ew, "counter", "bytes_sent_total", "Total number of bytes sent.", atomic.LoadInt64(&c.bytesSent))
+	c.writeMetric(ew, "counter", "decode_errors_total", "Total number of packet decode errors.", atomic.LoadInt64(&c.decodeErrors))
+
+	name := c.name("handle_duration_seconds")
+	ew.printf("# HELP %s Time spent by handler for a packet.\n", name)
+	ew.printf("# TYPE %s histogram\n", name)
+	var cumulative int64
+	for i, le := range c.buckets {
+		cumulative += atomic.LoadInt64(&c.bucketCounts[i])
+		ew.printf("%s_bucket{le=\"%g\"} %d\n", name, le, cumulative)
+	}
+	cumulative += atomic.LoadInt64(&c.bucketCounts[len(c.buckets)])
+	ew.printf("%s_bucket{le=\"+Inf\"} %d\n", name, cumulative)
+	ew.printf("%s_sum %g\n", name, time.Duration(atomic.LoadInt64(&c.handleSumNs)).Seconds())
+	ew.printf("%s_count %d\n", name, atomic.LoadInt64(&c.handleCount))
+
+	return ew.n, ew.err
+}
+
+func (c *PrometheusCollector) writeMetric(ew *errWriter, typ, name, help string, value int64) {
+	name = c.name(name)
+	ew.printf("# HELP %s %s\n", name, help)
+	ew.printf("# TYPE %s %s\n", name, typ)
+	ew.printf("%s %d\n", name, value)
+}
+
+func (c *PrometheusCollector) name(name string) string {
+	if c.namespace == "" {
+		return name
+	}
+	return c.namespace + "_" + name
+}
+
+// errWriter stops writing after the first error.
+type errWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(ew.w, format, args...)
+	ew.n += int64(n)
+	ew.err = err
+}
@@ -0,0 +1,57 @@
+package sockit
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware wraps a Handler to provide cross-cutting behaviour,
+// such as logging, recovery or metrics.
+type Middleware func(Handler) Handler
+
+// Chain wraps handler with middlewares. The first middleware is the outermost one,
+// e.g. Chain(h, A, B) handles packet in order A -> B -> h.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Recovery recovers panic raised by next Handler and logs it with the stack.
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(packet Packet, s *Session) {
+			defer func() {
+				if r := recover(); r != nil {
+					s.Logger().Error("handler panic", "packetId", packet.Id(), "panic", r, "stack", string(debug.Stack()))
+				}
+			}()
+			next.Handle(packet, s)
+		})
+	}
+}
+
+// Logging logs every packet passed to next Handler.
+func Logging() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(packet Packet, s *Session) {
+			s.Logger().Debug("handle packet", "packetId", packet.Id(), "packetType", fmt.Sprintf("%T", packet))
+			next.Handle(packet, s)
+		})
+	}
+}
+
+// Timing measures the duration of next Handler and reports it to fn.
+func Timing(fn func(packet Packet, s *Session, d time.Duration)) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(packet Packet, s *Session) {
+			start := time.Now()
+			defer func() {
+				fn(packet, s, time.Since(start))
+			}()
+			next.Handle(packet, s)
+		})
+	}
+}
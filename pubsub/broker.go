@@ -0,0 +1,172 @@
+// Package pubsub implements topic based publish/subscribe on top of sockit sessions.
+//
+// Topics are separated into levels by '/'. In subscribing patterns,
+// '+' matches exactly one level and '#' matches any remaining levels,
+// it must be the last level. e.g. "news/+/sport" matches "news/cn/sport",
+// "news/#" matches "news/cn/sport" and "news".
+package pubsub
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/chenqinghe/sockit"
+)
+
+// Broker tracks subscriptions of sessions and delivers published messages.
+//
+//	broker := pubsub.NewBroker(pubsub.TLVProtocol{SubscribeType: 10, UnsubscribeType: 11, PublishType: 12})
+//	mgr := sockit.NewManager(handler, &sockit.NewManagerOptions{
+//		Middlewares: []sockit.Middleware{broker.Middleware()},
+//	})
+//	broker.Publish("news/sport", payload)
+type Broker struct {
+	proto Protocol
+
+	mu   *sync.RWMutex
+	subs map[int64]*subscription
+}
+
+type subscription struct {
+	sess     *sockit.Session
+	patterns map[string]struct{}
+}
+
+func NewBroker(proto Protocol) *Broker {
+	return &Broker{
+		proto: proto,
+		mu:    &sync.RWMutex{},
+		subs:  make(map[int64]*subscription),
+	}
+}
+
+// Middleware handles Subscribe and Unsubscribe packets received by sessions,
+// other packets are passed to the next handler. Publish packets sent by clients are
+// passed to the next handler too, which decides whether to call Broker.Publish.
+func (b *Broker) Middleware() sockit.Middleware {
+	return func(next sockit.Handler) sockit.Handler {
+		return sockit.HandlerFunc(func(p sockit.Packet, s *sockit.Session) {
+			m, ok := b.proto.Parse(p)
+			if !ok {
+				next.Handle(p, s)
+				return
+			}
+
+			switch m.Kind {
+			case Subscribe:
+				b.Subscribe(s, m.Topic)
+			case Unsubscribe:
+				b.Unsubscribe(s, m.Topic)
+			default:
+				next.Handle(p, s)
+			}
+		})
+	}
+}
+
+// Subscribe subscribes the session to topics matching pattern.
+// The subscriptions are removed when the session closed.
+func (b *Broker) Subscribe(s *sockit.Session, pattern string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub, ok := b.subs[s.Id()]
+	if !ok {
+		sub = &subscription{
+			sess:     s,
+			patterns: make(map[string]struct{}),
+		}
+		b.subs[s.Id()] = sub
+		go b.watch(s)
+	}
+	sub.patterns[pattern] = struct{}{}
+}
+
+// watch removes all subscriptions of the session when it's closed.
+func (b *Broker) watch(s *sockit.Session) {
+	<-s.Done()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if sub, ok := b.subs[s.Id()]; ok && sub.sess == s {
+		delete(b.subs, s.Id())
+	}
+}
+
+// Unsubscribe removes the subscription of pattern of the session.
+func (b *Broker) Unsubscribe(s *sockit.Session, pattern string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if sub, ok := b.subs[s.Id()]; ok {
+		delete(sub.patterns, pattern)
+	}
+}
+
+// Subscriptions returns patterns subscribed by the session.
+func (b *Broker) Subscriptions(s *sockit.Session) []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	sub, ok := b.subs[s.Id()]
+	if !ok {
+		return nil
+	}
+	patterns := make([]string, 0, len(sub.patterns))
+	for p := range sub.patterns {
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// Publish delivers payload to all sessions subscribed the topic, by Session.TrySendPacketAsync,
+// so a slow subscriber doesn't block others, the message is discarded for subscribers whose
+// send queue is full. It returns the number of sessions the message delivered to.
+func (b *Broker) Publish(topic string, payload []byte) (int, error) {
+	pkt, err := b.proto.Packet(Message{Kind: Publish, Topic: topic, Payload: payload})
+	if err != nil {
+		return 0, err
+	}
+
+	var sessions []*sockit.Session
+	b.mu.RLock()
+	for _, sub := range b.subs {
+		for pattern := range sub.patterns {
+			if Match(pattern, topic) {
+				sessions = append(sessions, sub.sess)
+				break
+			}
+		}
+	}
+	b.mu.RUnlock()
+
+	n := 0
+	for _, s := range sessions {
+		if err := s.TrySendPacketAsync(pkt); err != nil {
+			s.Logger().Error("publish message error", "topic", topic, "error", err.Error())
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Match reports whether topic matches pattern.
+func Match(pattern, topic string) bool {
+	pl := strings.Split(pattern, "/")
+	tl := strings.Split(topic, "/")
+
+	for i, p := range pl {
+		if p == "#" {
+			return i == len(pl)-1
+		}
+		if i >= len(tl) {
+			return false
+		}
+		if p != "+" && p != tl[i] {
+			return false
+		}
+	}
+	return len(pl) == len(tl)
+}
@@ -0,0 +1,202 @@
+package pubsub
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chenqinghe/sockit"
+	"github.com/chenqinghe/sockit/codec"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"news/sport", "news/sport", true},
+		{"news/sport", "news", false},
+		{"news/sport", "news/sport/cn", false},
+		{"news/+/sport", "news/cn/sport", true},
+		{"news/+/sport", "news/sport", false},
+		{"news/+", "news/cn/sport", false},
+		{"news/+", "news/", true},
+		{"+", "news", true},
+		{"+", "news/cn", false},
+		{"+/+", "/news", true},
+		{"news/#", "news/cn/sport", true},
+		{"news/#", "news", true},
+		{"news/#", "newspaper", false},
+		{"#", "news/cn/sport", true},
+		{"#", "", true},
+		{"+/#", "news", true},
+		{"news/#/sport", "news/cn/sport", false},
+		{"news+", "news1", false},
+		{"news#", "news", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.topic); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+		}
+	}
+}
+
+var testProto = TLVProtocol{SubscribeType: 10, UnsubscribeType: 11, PublishType: 12}
+
+type message struct {
+	topic   string
+	payload string
+}
+
+// startBroker serves a broker on a loopback address.
+func startBroker(t *testing.T) (*Broker, *sockit.Manager, string) {
+	t.Helper()
+	broker := NewBroker(testProto)
+	mgr := sockit.NewManager(sockit.HandlerFunc(func(sockit.Packet, *sockit.Session) {}), &sockit.NewManagerOptions{
+		Middlewares: []sockit.Middleware{broker.Middleware()},
+		Logger:      sockit.NopLogger{},
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := sockit.NewServer(mgr, codec.TLVCodec{})
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return broker, mgr, l.Addr().String()
+}
+
+// newTestClient returns a client passing messages published to received.
+func newTestClient(received chan<- message, opts *sockit.NewClientOptions) *sockit.Client {
+	opts.Logger = sockit.NopLogger{}
+	opts.Middlewares = []sockit.Middleware{MessageMiddleware(testProto, func(s *sockit.Session, topic string, payload []byte) {
+		received <- message{topic: topic, payload: string(payload)}
+	})}
+	return sockit.NewClient(codec.TLVCodec{}, sockit.HandlerFunc(func(sockit.Packet, *sockit.Session) {}), opts)
+}
+
+// serverSession returns the only session of mgr.
+func serverSession(t *testing.T, mgr *sockit.Manager) *sockit.Session {
+	t.Helper()
+	var sess *sockit.Session
+	waitFor(t, "server session", func() bool {
+		mgr.RangeSession(func(s *sockit.Session) { sess = s })
+		return sess != nil
+	})
+	return sess
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func recvMessage(t *testing.T, ch <-chan message) message {
+	t.Helper()
+	select {
+	case m := <-ch:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting message")
+		return message{}
+	}
+}
+
+func TestBroker(t *testing.T) {
+	broker, mgr, addr := startBroker(t)
+
+	received := make(chan message, 4)
+	cli := newTestClient(received, &sockit.NewClientOptions{})
+	defer cli.Close()
+	sess, err := cli.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := NewSubscriber(cli, sess, testProto)
+	if err := sub.Subscribe("news/#"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Subscribe("weather/+"); err != nil {
+		t.Fatal(err)
+	}
+
+	ss := serverSession(t, mgr)
+	waitFor(t, "subscriptions", func() bool { return len(broker.Subscriptions(ss)) == 2 })
+
+	if n, err := broker.Publish("news/sport", []byte("goal")); err != nil || n != 1 {
+		t.Fatalf("Publish = %d, %v", n, err)
+	}
+	if m := recvMessage(t, received); m != (message{"news/sport", "goal"}) {
+		t.Fatalf("received %+v", m)
+	}
+	if n, _ := broker.Publish("weather/cn/sh", []byte("rain")); n != 0 {
+		t.Fatalf("delivered %d messages to unmatched subscriber", n)
+	}
+
+	if err := sub.Unsubscribe("news/#"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "unsubscribed", func() bool { return len(broker.Subscriptions(ss)) == 1 })
+	if n, _ := broker.Publish("news/sport", nil); n != 0 {
+		t.Fatalf("delivered %d messages after unsubscribed", n)
+	}
+
+	// subscriptions are removed when the session closed
+	sess.Close()
+	waitFor(t, "subscriptions removed", func() bool { return broker.Subscriptions(ss) == nil })
+	if n, _ := broker.Publish("weather/sh", nil); n != 0 {
+		t.Fatalf("delivered %d messages to closed session", n)
+	}
+}
+
+type retryPolicy time.Duration
+
+func (p retryPolicy) Retry() bool        { return true }
+func (p retryPolicy) Timer() *time.Timer { return time.NewTimer(time.Duration(p)) }
+
+func TestSubscriberResubscribe(t *testing.T) {
+	broker, mgr, addr := startBroker(t)
+
+	received := make(chan message, 4)
+	reconnected := make(chan struct{}, 1)
+	cli := newTestClient(received, &sockit.NewClientOptions{
+		NeedReconnect:   true,
+		ReconnectPolicy: retryPolicy(10 * time.Millisecond),
+	})
+	defer cli.Close()
+	sess, err := cli.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := NewSubscriber(cli, sess, testProto)
+	cli.OnReconnect(func(s *sockit.Session) { reconnected <- struct{}{} })
+	if err := sub.Subscribe("news/#"); err != nil {
+		t.Fatal(err)
+	}
+
+	old := serverSession(t, mgr)
+	waitFor(t, "subscriptions", func() bool { return len(broker.Subscriptions(old)) == 1 })
+
+	// the server drops the connection, the client reconnects and subscribes again
+	mgr.RemoveSession(old.Id())
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting reconnect")
+	}
+	ss := serverSession(t, mgr)
+	waitFor(t, "resubscribed", func() bool { return len(broker.Subscriptions(ss)) == 1 })
+
+	if n, err := broker.Publish("news/sport", []byte("goal")); err != nil || n != 1 {
+		t.Fatalf("Publish = %d, %v", n, err)
+	}
+	if m := recvMessage(t, received); m != (message{"news/sport", "goal"}) {
+		t.Fatalf("received %+v", m)
+	}
+}
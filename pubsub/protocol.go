@@ -0,0 +1,180 @@
+package pubsub
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenqinghe/sockit"
+	"github.com/chenqinghe/sockit/codec"
+)
+
+// Kind is the kind of a pubsub message.
+type Kind int
+
+const (
+	Subscribe Kind = iota + 1
+	Unsubscribe
+	Publish
+)
+
+// Message is a pubsub control or data message carried by packets.
+type Message struct {
+	Kind Kind
+
+	// Topic is the topic pattern for Subscribe and Unsubscribe,
+	// or the topic published to for Publish.
+	Topic string
+
+	// Payload is the published data, only used by Publish.
+	Payload []byte
+}
+
+// Protocol converts between messages and packets of a codec.
+type Protocol interface {
+	// Parse returns the message carried by packet, false if the packet is not a pubsub packet.
+	Parse(p sockit.Packet) (Message, bool)
+
+	// Packet builds a packet carrying the message.
+	Packet(m Message) (sockit.Packet, error)
+}
+
+var idGen int64
+
+// TLVProtocol carries messages by codec.TLVPacket, message kinds are
+// distinguished by packet type. Data of Subscribe and Unsubscribe packet is
+// the topic, Data of Publish packet is 2 bytes topic length, topic and payload.
+type TLVProtocol struct {
+	SubscribeType   int32
+	UnsubscribeType int32
+	PublishType     int32
+}
+
+var _ Protocol = TLVProtocol{}
+
+func (tp TLVProtocol) Parse(p sockit.Packet) (Message, bool) {
+	pkt, ok := p.(codec.TLVPacket)
+	if !ok {
+		return Message{}, false
+	}
+
+	switch pkt.Type {
+	case tp.SubscribeType:
+		return Message{Kind: Subscribe, Topic: string(pkt.Data)}, true
+	case tp.UnsubscribeType:
+		return Message{Kind: Unsubscribe, Topic: string(pkt.Data)}, true
+	case tp.PublishType:
+		if len(pkt.Data) < 2 {
+			return Message{}, false
+		}
+		n := int(binary.BigEndian.Uint16(pkt.Data))
+		if len(pkt.Data) < 2+n {
+			return Message{}, false
+		}
+		return Message{Kind: Publish, Topic: string(pkt.Data[2 : 2+n]), Payload: pkt.Data[2+n:]}, true
+	default:
+		return Message{}, false
+	}
+}
+
+func (tp TLVProtocol) Packet(m Message) (sockit.Packet, error) {
+	pkt := codec.TLVPacket{
+		PacketHead: codec.PacketHead{
+			ID:        atomic.AddInt64(&idGen, 1),
+			Timestamp: time.Now().UnixNano() / 1e6,
+		},
+	}
+
+	switch m.Kind {
+	case Subscribe:
+		pkt.Type = tp.SubscribeType
+		pkt.Data = []byte(m.Topic)
+	case Unsubscribe:
+		pkt.Type = tp.UnsubscribeType
+		pkt.Data = []byte(m.Topic)
+	case Publish:
+		if len(m.Topic) > 0xffff {
+			return nil, errors.New("topic too long")
+		}
+		pkt.Type = tp.PublishType
+		pkt.Data = make([]byte, 2, 2+len(m.Topic)+len(m.Payload))
+		binary.BigEndian.PutUint16(pkt.Data, uint16(len(m.Topic)))
+		pkt.Data = append(append(pkt.Data, m.Topic...), m.Payload...)
+	default:
+		return nil, errors.New("unknown message kind")
+	}
+	pkt.Length = uint64(len(pkt.Data))
+
+	return pkt, nil
+}
+
+// JsonProtocol carries messages by codec.JsonPacket, message kinds are distinguished
+// by packet subject. Data of packet is {"topic":"...","payload":...}, payload must be valid json.
+type JsonProtocol struct {
+	SubscribeSubject   int32
+	UnsubscribeSubject int32
+	PublishSubject     int32
+}
+
+var _ Protocol = JsonProtocol{}
+
+type jsonMessage struct {
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+func (jp JsonProtocol) Parse(p sockit.Packet) (Message, bool) {
+	pkt, ok := p.(codec.JsonPacket)
+	if !ok {
+		return Message{}, false
+	}
+
+	var m Message
+	switch pkt.Subject {
+	case jp.SubscribeSubject:
+		m.Kind = Subscribe
+	case jp.UnsubscribeSubject:
+		m.Kind = Unsubscribe
+	case jp.PublishSubject:
+		m.Kind = Publish
+	default:
+		return Message{}, false
+	}
+
+	var jm jsonMessage
+	if err := json.Unmarshal(pkt.Data, &jm); err != nil {
+		return Message{}, false
+	}
+	m.Topic = jm.Topic
+	m.Payload = jm.Payload
+
+	return m, true
+}
+
+func (jp JsonProtocol) Packet(m Message) (sockit.Packet, error) {
+	pkt := codec.JsonPacket{
+		ID:        atomic.AddInt64(&idGen, 1),
+		Timestamp: time.Now().Unix(),
+	}
+
+	switch m.Kind {
+	case Subscribe:
+		pkt.Subject = jp.SubscribeSubject
+	case Unsubscribe:
+		pkt.Subject = jp.UnsubscribeSubject
+	case Publish:
+		pkt.Subject = jp.PublishSubject
+	default:
+		return nil, errors.New("unknown message kind")
+	}
+
+	data, err := json.Marshal(jsonMessage{Topic: m.Topic, Payload: m.Payload})
+	if err != nil {
+		return nil, err
+	}
+	pkt.Data = data
+
+	return pkt, nil
+}
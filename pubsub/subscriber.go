@@ -0,0 +1,90 @@
+package pubsub
+
+import (
+	"sync"
+
+	"github.com/chenqinghe/sockit"
+)
+
+// Subscriber manages subscriptions of a client session,
+// and re-subscribes all patterns after the session reconnected.
+type Subscriber struct {
+	proto Protocol
+	sess  *sockit.Session
+
+	mu       *sync.Mutex
+	patterns map[string]struct{}
+}
+
+// NewSubscriber creates a Subscriber of sess, which is dialed by cli.
+func NewSubscriber(cli *sockit.Client, sess *sockit.Session, proto Protocol) *Subscriber {
+	sub := &Subscriber{
+		proto:    proto,
+		sess:     sess,
+		mu:       &sync.Mutex{},
+		patterns: make(map[string]struct{}),
+	}
+	cli.OnReconnect(func(s *sockit.Session) {
+		if s == sub.sess {
+			sub.resubscribe()
+		}
+	})
+	return sub
+}
+
+func (sub *Subscriber) Subscribe(pattern string) error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if err := sub.send(Message{Kind: Subscribe, Topic: pattern}); err != nil {
+		return err
+	}
+	sub.patterns[pattern] = struct{}{}
+	return nil
+}
+
+func (sub *Subscriber) Unsubscribe(pattern string) error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	delete(sub.patterns, pattern)
+	return sub.send(Message{Kind: Unsubscribe, Topic: pattern})
+}
+
+// Publish sends a Publish message to server, it's up to the server whether to deliver it.
+func (sub *Subscriber) Publish(topic string, payload []byte) error {
+	return sub.send(Message{Kind: Publish, Topic: topic, Payload: payload})
+}
+
+func (sub *Subscriber) resubscribe() {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	for pattern := range sub.patterns {
+		if err := sub.send(Message{Kind: Subscribe, Topic: pattern}); err != nil {
+			sub.sess.Logger().Error("resubscribe error", "pattern", pattern, "error", err.Error())
+		}
+	}
+}
+
+func (sub *Subscriber) send(m Message) error {
+	pkt, err := sub.proto.Packet(m)
+	if err != nil {
+		return err
+	}
+	return sub.sess.SendPacket(pkt)
+}
+
+// MessageMiddleware calls fn with published messages received,
+// other packets are passed to the next handler.
+func MessageMiddleware(proto Protocol, fn func(s *sockit.Session, topic string, payload []byte)) sockit.Middleware {
+	return func(next sockit.Handler) sockit.Handler {
+		return sockit.HandlerFunc(func(p sockit.Packet, s *sockit.Session) {
+			if m, ok := proto.Parse(p); ok && m.Kind == Publish {
+				fn(s, m.Topic, m.Payload)
+				return
+			}
+			next.Handle(p, s)
+		})
+	}
+}
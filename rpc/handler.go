@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
-	"log"
 	"time"
 
 	"github.com/chenqinghe/sockit"
@@ -12,52 +11,72 @@ import (
 )
 
 type DispatchHandler struct {
-	handlers map[int32]func(pkt sockit.Packet, s *sockit.Session)
+	handlers map[int32]func(pkt Dispatchable, s *sockit.Session)
 }
 
 func NewDispatchHandler() *DispatchHandler {
 	return &DispatchHandler{
-		handlers: make(map[int32]func(pkt sockit.Packet, s *sockit.Session)),
+		handlers: make(map[int32]func(pkt Dispatchable, s *sockit.Session)),
 	}
 }
 
+// Dispatchable is a packet which can be dispatched by DispatchHandler,
+// codec.TLVPacket and codec.ProtobufPacket implement it.
+type Dispatchable interface {
+	sockit.Packet
+
+	// PacketType is the type packets dispatched by.
+	PacketType() int32
+
+	// Payload is the data passed to HandleFunc.
+	Payload() []byte
+
+	// Response creates the response packet carrying data.
+	Response(data []byte) sockit.Packet
+}
+
+var (
+	_ Dispatchable = codec.TLVPacket{}
+	_ Dispatchable = codec.ProtobufPacket{}
+)
+
 func (dh *DispatchHandler) Register(typ int32, fn HandleFunc) {
-	dh.handlers[typ] = func(pkt sockit.Packet, s *sockit.Session) {
-		tlvPkt := pkt.(codec.TLVPacket)
+	dh.handlers[typ] = func(pkt Dispatchable, s *sockit.Session) {
 		buf := bytes.NewBuffer(nil)
-		fn(&Packet{pkt: &tlvPkt}, &Session{
+		fn(&Packet{pkt: pkt}, &Session{
 			Writer:  buf,
 			Session: s,
 		})
-		s.SendPacket(codec.TLVPacket{
-			PacketHead: codec.PacketHead{
-				Type:      int32(1<<30) | tlvPkt.Type,
-				ID:        pkt.Id(),
-				Timestamp: time.Now().UnixNano(),
-				Length:    uint64(buf.Len()),
-			},
-			Data: buf.Bytes(),
-		})
+		s.SendPacket(pkt.Response(buf.Bytes()))
 	}
 }
 
 func (dh *DispatchHandler) Handle(p sockit.Packet, s *sockit.Session) {
-	pkt := p.(codec.TLVPacket)
-	if fn, ok := dh.handlers[pkt.Type]; ok {
+	pkt, ok := p.(Dispatchable)
+	if !ok {
+		s.Logger().Warn("packet not dispatchable", "packetId", p.Id())
+		return
+	}
+	if fn, ok := dh.handlers[pkt.PacketType()]; ok {
 		fn(pkt, s)
 	} else {
-		log.Printf("unknown packet type: %d\n", pkt.Type)
+		s.Logger().Warn("unknown packet type", "type", pkt.PacketType())
 	}
 }
 
 type HandleFunc func(pkt *Packet, s *Session)
 
 type Packet struct {
-	pkt *codec.TLVPacket
+	pkt Dispatchable
 }
 
 func (p Packet) Data() io.Reader {
-	return bytes.NewBuffer(p.pkt.Data)
+	return bytes.NewBuffer(p.pkt.Payload())
+}
+
+// Raw returns the packet decoded by codec, e.g. codec.ProtobufPacket.
+func (p Packet) Raw() sockit.Packet {
+	return p.pkt
 }
 
 func (p Packet) Time() time.Time {
@@ -69,11 +88,11 @@ func (p Packet) Id() int64 {
 }
 
 func (p Packet) Length() int {
-	return int(p.pkt.Length)
+	return len(p.pkt.Payload())
 }
 
 func (p Packet) BindJson(v interface{}) error {
-	return json.Unmarshal(p.pkt.Data, v)
+	return json.Unmarshal(p.pkt.Payload(), v)
 }
 
 type Session struct {
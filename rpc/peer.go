@@ -57,6 +57,7 @@ func (p *Peer) Dial(network string, addr string) (*sockit.Session, error) {
 			OnClosed:        func(session *sockit.Session) {},
 			NeedReconnect:   true,
 			ReconnectPolicy: reconnectpolicy.NewConstTime(time.Second),
+			Correlator:      codec.TLVCorrelator{},
 		})
 	})
 	return p.client.Dial(network, addr)
@@ -67,6 +68,7 @@ func (p *Peer) ListenAndServe(addr string) error {
 		manager := sockit.NewManager(p.opt.SrvHandler, &sockit.NewManagerOptions{
 			Authenticator: p.opt.Authenticator,
 			KeepaliveTick: time.Second * 2,
+			Correlator:    codec.TLVCorrelator{},
 		})
 		manager.SetKeepAlive(true)
 		p.server = sockit.NewServer(manager, codec.TLVCodec{
@@ -0,0 +1,203 @@
+package sockit
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy specify what to do when the send queue of a session is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the sender until there is room in the queue.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropNew discards the packet being sent.
+	OverflowDropNew
+
+	// OverflowDropOldest discards the oldest packet in the queue.
+	OverflowDropOldest
+
+	// OverflowDisconnect closes the session, it's used to kick out slow consumers.
+	OverflowDisconnect
+)
+
+const defaultSendQueueSize = 256
+
+// sendQueueFlushTimeout is the max time to write queued packets
+// when session is closing.
+var sendQueueFlushTimeout = 5 * time.Second
+
+var (
+	ErrSendQueueFull = errors.New("send queue full")
+	ErrSessionClosed = errors.New("session closed")
+)
+
+// sendQueueProvider is implemented by ConnManager which configures send queue of sessions.
+type sendQueueProvider interface {
+	sendQueueOptions() (size int, policy OverflowPolicy)
+}
+
+// sendQueue is a bounded outbound queue of a session, which is drained
+// by a writer goroutine.
+//
+// The queue doesn't refer to the Session, since Client replaces the Session
+// in place when reconnected while the writer may be still flushing the old connection.
+type sendQueue struct {
+	c      Conn
+	logger Logger
+	policy OverflowPolicy
+
+	// disconnect removes the session when the queue is full in OverflowDisconnect policy.
+	disconnect func()
+
+	mu     *sync.Mutex // serialize producers when dropping oldest
+	queue  chan Packet
+	closed <-chan struct{} // closed when the session closed
+	done   chan struct{}   // closed when queued packets flushed and connection closed
+}
+
+func newSendQueue(s *Session, size int, policy OverflowPolicy) *sendQueue {
+	if size <= 0 {
+		size = defaultSendQueueSize
+	}
+
+	mgr, id := s.mgr, s.id
+	q := &sendQueue{
+		c:          s.c,
+		logger:     s.logger,
+		policy:     policy,
+		disconnect: func() { mgr.RemoveSession(id) },
+		mu:         &sync.Mutex{},
+		queue:      make(chan Packet, size),
+		closed:     s.closed,
+		done:       make(chan struct{}),
+	}
+	go q.loop()
+	return q
+}
+
+func (q *sendQueue) push(p Packet) error {
+	return q.pushPolicy(p, q.policy)
+}
+
+// tryPush is like push but never blocks, packet is discarded
+// if the queue is full in OverflowBlock policy.
+func (q *sendQueue) tryPush(p Packet) error {
+	policy := q.policy
+	if policy == OverflowBlock {
+		policy = OverflowDropNew
+	}
+	return q.pushPolicy(p, policy)
+}
+
+func (q *sendQueue) pushPolicy(p Packet, policy OverflowPolicy) error {
+	select {
+	case <-q.closed:
+		return ErrSessionClosed
+	default:
+	}
+
+	switch policy {
+	case OverflowDropNew:
+		select {
+		case q.queue <- p:
+			return nil
+		default:
+			return ErrSendQueueFull
+		}
+	case OverflowDropOldest:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for {
+			select {
+			case q.queue <- p:
+				return nil
+			default:
+			}
+			select {
+			case <-q.queue:
+			default:
+			}
+		}
+	case OverflowDisconnect:
+		select {
+		case q.queue <- p:
+			return nil
+		default:
+			go q.disconnect()
+			return ErrSendQueueFull
+		}
+	default:
+		select {
+		case q.queue <- p:
+			return nil
+		case <-q.closed:
+			return ErrSessionClosed
+		}
+	}
+}
+
+// maxSendBatch is the max number of queued packets written with one flush.
+const maxSendBatch = 64
+
+// batchSender is implemented by Conn which can send multiple packets at once.
+type batchSender interface {
+	sendPackets(packets ...Packet) error
+}
+
+func (q *sendQueue) loop() {
+	defer close(q.done)
+
+	batch := make([]Packet, 0, maxSendBatch)
+	for {
+		select {
+		case p := <-q.queue:
+			batch = q.collect(append(batch[:0], p))
+			q.write(batch)
+		case <-q.closed:
+			// flush packets remained, the write deadline has been set by Session.close
+			for {
+				batch = q.collect(batch[:0])
+				if len(batch) == 0 {
+					break
+				}
+				q.write(batch)
+			}
+			if err := q.c.Close(); err != nil {
+				q.logger.Error("close connection error", "error", err.Error())
+			}
+			return
+		}
+	}
+}
+
+// collect appends packets already in queue to batch without blocking.
+func (q *sendQueue) collect(batch []Packet) []Packet {
+	for len(batch) < maxSendBatch {
+		select {
+		case p := <-q.queue:
+			batch = append(batch, p)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (q *sendQueue) write(batch []Packet) {
+	var err error
+	if bs, ok := q.c.(batchSender); ok {
+		err = bs.sendPackets(batch...)
+	} else {
+		for _, p := range batch {
+			if err = q.c.SendPacket(p); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		q.logger.Error("send packet error", "error", err.Error())
+	}
+}
@@ -0,0 +1,166 @@
+package sockit
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stalledSession returns a session with send queue of size 2, whose writer is
+// blocked in writing packet 1 until the gate of conn closed.
+func stalledSession(t *testing.T, policy OverflowPolicy) (*Session, *fakeConn) {
+	t.Helper()
+	mgr := NewManager(HandlerFunc(func(Packet, *Session) {}), &NewManagerOptions{
+		SendQueueSize:   2,
+		SendQueuePolicy: policy,
+	})
+	t.Cleanup(func() { mgr.Close() })
+
+	c := newFakeConn()
+	c.gate = make(chan struct{})
+	c.writing = make(chan Packet, 16)
+	s, err := mgr.StoreConn(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SendPacketAsync(testPacket{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	recvPacket(t, c.writing)
+	return s, c
+}
+
+func sentIds(c *fakeConn) []int64 {
+	var ids []int64
+	for _, p := range c.Sent() {
+		ids = append(ids, p.Id())
+	}
+	return ids
+}
+
+func sendAll(t *testing.T, s *Session, ids ...int64) {
+	t.Helper()
+	for _, id := range ids {
+		if err := s.SendPacketAsync(testPacket{ID: id}); err != nil {
+			t.Fatalf("send packet %d: %v", id, err)
+		}
+	}
+}
+
+func TestSendQueueOverflow(t *testing.T) {
+	tests := []struct {
+		policy  OverflowPolicy
+		wantErr error
+		want    []int64
+	}{
+		{OverflowDropNew, ErrSendQueueFull, []int64{1, 2, 3}},
+		{OverflowDropOldest, nil, []int64{1, 3, 4}},
+	}
+	for _, tt := range tests {
+		s, c := stalledSession(t, tt.policy)
+		sendAll(t, s, 2, 3)
+
+		if err := s.SendPacketAsync(testPacket{ID: 4}); err != tt.wantErr {
+			t.Fatalf("policy %d: err = %v, want %v", tt.policy, err, tt.wantErr)
+		}
+
+		close(c.gate)
+		waitFor(t, "packets sent", func() bool { return len(c.Sent()) == len(tt.want) })
+		if ids := sentIds(c); !reflect.DeepEqual(ids, tt.want) {
+			t.Fatalf("policy %d: sent %v, want %v", tt.policy, ids, tt.want)
+		}
+	}
+}
+
+func TestSendQueueOverflowBlock(t *testing.T) {
+	s, c := stalledSession(t, OverflowBlock)
+	sendAll(t, s, 2, 3)
+
+	if err := s.TrySendPacketAsync(testPacket{ID: 5}); err != ErrSendQueueFull {
+		t.Fatalf("TrySendPacketAsync err = %v, want ErrSendQueueFull", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.SendPacketAsync(testPacket{ID: 4}) }()
+	select {
+	case err := <-done:
+		t.Fatalf("SendPacketAsync returned %v while queue full", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(c.gate)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "packets sent", func() bool { return len(c.Sent()) == 4 })
+	if ids := sentIds(c); !reflect.DeepEqual(ids, []int64{1, 2, 3, 4}) {
+		t.Fatalf("sent %v", ids)
+	}
+}
+
+func TestSendQueueOverflowDisconnect(t *testing.T) {
+	s, c := stalledSession(t, OverflowDisconnect)
+	defer close(c.gate)
+	sendAll(t, s, 2, 3)
+
+	if err := s.SendPacketAsync(testPacket{ID: 4}); err != ErrSendQueueFull {
+		t.Fatalf("err = %v, want ErrSendQueueFull", err)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session not closed")
+	}
+	if err := s.SendPacketAsync(testPacket{ID: 5}); err != ErrSessionClosed {
+		t.Fatalf("err = %v, want ErrSessionClosed", err)
+	}
+}
+
+func TestSendQueueFlushOnClose(t *testing.T) {
+	s, c := stalledSession(t, OverflowBlock)
+	sendAll(t, s, 2, 3)
+
+	start := time.Now()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Close blocked %v", d)
+	}
+	if c.isClosed() {
+		t.Fatal("connection closed before queued packets flushed")
+	}
+
+	close(c.gate)
+	waitFor(t, "connection closed", c.isClosed)
+	if ids := sentIds(c); !reflect.DeepEqual(ids, []int64{1, 2, 3}) {
+		t.Fatalf("sent %v, want all queued packets", ids)
+	}
+}
+
+func TestSendQueueFlushTimeout(t *testing.T) {
+	// the peer never reads
+	s, c := stalledSession(t, OverflowBlock)
+	c.deadline = 100 * time.Millisecond
+	sendAll(t, s, 2)
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "connection closed", c.isClosed)
+	if ids := sentIds(c); len(ids) != 0 {
+		t.Fatalf("sent %v to a stalled peer", ids)
+	}
+}
+
+func TestTrySendPacketAsyncClosed(t *testing.T) {
+	s, c := stalledSession(t, OverflowDropNew)
+	close(c.gate)
+	s.Close()
+
+	if err := s.TrySendPacketAsync(testPacket{ID: 2}); !errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("err = %v, want ErrSessionClosed", err)
+	}
+}
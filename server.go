@@ -1,6 +1,7 @@
 package sockit
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -29,12 +30,60 @@ type ConnManager interface {
 	Close() error
 }
 
+// GracefulConnManager is a ConnManager which supports graceful shutdown.
+type GracefulConnManager interface {
+	ConnManager
+
+	// Shutdown waits all sessions become idle and then closes them.
+	// When ctx is done, sessions remained will be closed immediately.
+	Shutdown(ctx context.Context) error
+}
+
+// UserConnManager is a ConnManager which manages sessions by user id.
+type UserConnManager interface {
+	ConnManager
+
+	// FindSessionByUser returns all sessions of the user.
+	FindSessionByUser(id string) ([]*Session, bool)
+
+	// SendToUser sends the packet to all sessions of the user.
+	SendToUser(id string, p Packet) error
+
+	// KickUser closes all sessions of the user.
+	KickUser(id string, reason string) error
+}
+
+var ErrUserNotFound = errors.New("user not found")
+
+// GroupConnManager is a ConnManager which supports grouping sessions.
+type GroupConnManager interface {
+	ConnManager
+
+	// Join adds the session into group.
+	Join(sessionId int64, group string) error
+
+	// Leave removes the session from group.
+	Leave(sessionId int64, group string)
+
+	// GroupMembers returns all sessions in the group.
+	GroupMembers(group string) []*Session
+
+	// Broadcast sends the packet to all sessions in the group except sessions excluded.
+	Broadcast(group string, p Packet, exclude ...int64) error
+}
+
 type Server struct {
 	listener net.Listener
 
 	Codec   Codec
 	Manager ConnManager
 
+	// ConnOptions configures buffering of accepted connections.
+	ConnOptions *ConnOptions
+
+	// Logger is used to log server events. Default is DefaultLogger.
+	Logger Logger
+
 	closed int32
 }
 
@@ -90,12 +139,21 @@ func (s *Server) Serve(listener net.Listener) error {
 			return err
 		}
 
-		s.Manager.StoreConn(newConn(c, s.Codec))
+		if _, err := s.Manager.StoreConn(newConn(c, s.Codec, s.ConnOptions)); err != nil {
+			s.logger().Debug("store connection error", "remoteAddr", c.RemoteAddr().String(), "error", err.Error())
+		}
 	}
 
 	return nil
 }
 
+func (s *Server) logger() Logger {
+	if s.Logger == nil {
+		return DefaultLogger
+	}
+	return s.Logger
+}
+
 // Close the listener and ConnManager.
 func (s *Server) Close() error {
 	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
@@ -112,3 +170,22 @@ func (s *Server) Close() error {
 
 	return nil
 }
+
+// Shutdown gracefully shuts down the server. It stops accepting new connections,
+// then shuts down the Manager if it implements GracefulConnManager, otherwise
+// the Manager is closed directly.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		return nil
+	}
+
+	if err := s.listener.Close(); err != nil {
+		return err
+	}
+
+	if mgr, ok := s.Manager.(GracefulConnManager); ok {
+		return mgr.Shutdown(ctx)
+	}
+
+	return s.Manager.Close()
+}
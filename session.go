@@ -3,8 +3,8 @@ package sockit
 import (
 	"context"
 	"errors"
-	"github.com/sirupsen/logrus"
 	"net"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -22,10 +22,21 @@ type Session struct {
 	dataLock *sync.RWMutex
 	data     map[string]interface{} // 用户自定义数据
 
-	lastPackTs time.Time // 最后一个收到包的时间
+	createdAt  time.Time
+	lastPackTs int64 // 最后一个收到包的时间, unix nano, accessed atomically
 
-	reqLock  *sync.RWMutex
-	requests map[int64]chan Packet
+	reqLock    *sync.RWMutex
+	requests   map[int64]*pendingRequest
+	correlator Correlator
+
+	handling   int32 // number of running Handler.Handle
+	dispatcher dispatcher
+
+	sendQueue *sendQueue
+
+	metrics MetricsCollector
+	logger  Logger
+	tracer  Tracer
 
 	manuallyClosed bool
 	closed         chan struct{}
@@ -43,9 +54,48 @@ func NewSession(c Conn, mgr ConnManager, user User, handler Handler) *Session {
 		dataLock:   &sync.RWMutex{},
 		data:       make(map[string]interface{}),
 		reqLock:    &sync.RWMutex{},
-		requests:   make(map[int64]chan Packet),
+		requests:   make(map[int64]*pendingRequest),
 		closed:     make(chan struct{}),
-		lastPackTs: time.Now(),
+		createdAt:  time.Now(),
+		lastPackTs: time.Now().UnixNano(),
+	}
+
+	if p, ok := mgr.(dispatcherProvider); ok {
+		sess.dispatcher = p.newDispatcher(sess)
+	} else {
+		sess.dispatcher = goroutineDispatcher{}
+	}
+
+	logger := DefaultLogger
+	if p, ok := mgr.(loggerProvider); ok && p.logger() != nil {
+		logger = p.logger()
+	}
+	sess.logger = logger.With("sessionId", sess.id, "remoteAddr", c.RemoteAddr().String())
+
+	if p, ok := mgr.(tapProvider); ok && p.tap() != nil {
+		sess.SetTap(p.tap())
+	}
+
+	sess.tracer = nopTracer{}
+	if p, ok := mgr.(tracerProvider); ok && p.tracer() != nil {
+		sess.tracer = p.tracer()
+	}
+
+	sess.metrics = nopMetrics{}
+	if p, ok := mgr.(metricsProvider); ok && p.metrics() != nil {
+		sess.metrics = p.metrics()
+	}
+
+	sess.correlator = MarkerCorrelator{}
+	if p, ok := mgr.(correlatorProvider); ok && p.correlator() != nil {
+		sess.correlator = p.correlator()
+	}
+
+	if p, ok := mgr.(sendQueueProvider); ok {
+		size, policy := p.sendQueueOptions()
+		sess.sendQueue = newSendQueue(sess, size, policy)
+	} else {
+		sess.sendQueue = newSendQueue(sess, defaultSendQueueSize, OverflowBlock)
 	}
 
 	go sess.readPacket()
@@ -58,42 +108,124 @@ func (s *Session) readPacket() {
 		packet, err := s.c.ReadPacket()
 		if err != nil {
 			if !errors.Is(err, net.ErrClosed) {
-				logrus.WithFields(logrus.Fields{
-					"remoteAddr": s.c.RemoteAddr().String(),
-					"sessionId":  s.Id(),
-				}).Errorln("read packet error:", err.Error())
+				s.logger.Error("read packet error", "error", err.Error())
 			}
 			s.mgr.RemoveSession(s.Id())
 			return
 		}
 
-		logrus.WithFields(logrus.Fields{
-			"remoteAddr": s.c.RemoteAddr().String(),
-			"sessionId":  s.Id(),
-		}).Debug("receive a packet")
-
-		s.lastPackTs = time.Now()
-
-		s.reqLock.RLock()
-		ch, ok := s.requests[packet.Id()]
-		s.reqLock.RUnlock()
-		if ok {
-			ch <- packet
-			close(ch)
-			s.reqLock.Lock()
-			delete(s.requests, packet.Id())
-			s.reqLock.Unlock()
+		s.logger.Debug("receive a packet")
+
+		atomic.StoreInt64(&s.lastPackTs, time.Now().UnixNano())
+
+		if req, ok := s.takeRequest(packet); ok {
+			req.ch <- packet
+			close(req.ch)
+			req.span.End()
 		} else {
-			go s.handler.Handle(packet, s)
+			atomic.AddInt32(&s.handling, 1)
+			if !s.dispatcher.dispatch(s, packet, func() {
+				defer atomic.AddInt32(&s.handling, -1)
+				s.handle(packet)
+			}) {
+				atomic.AddInt32(&s.handling, -1)
+			}
 		}
 	}
 }
 
+// panicHandler is implemented by ConnManager which wants to be notified
+// when Handler panics.
+type panicHandler interface {
+	handlePanic(s *Session, packet Packet, recovered interface{}, stack []byte)
+}
+
+// handle calls Handler with panic recovered, so a panicking handler
+// can not crash the whole process.
+func (s *Session) handle(packet Packet) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			if h, ok := s.mgr.(panicHandler); ok {
+				h.handlePanic(s, packet, r, stack)
+				return
+			}
+			s.logger.Error("handler panic", "panic", r, "stack", string(stack))
+		}
+	}()
+
+	start := time.Now()
+	_, span := s.tracer.Start(extractTraceContext(s.tracer, packet), spanHandle, start,
+		"sessionId", s.id, "packetId", packet.Id())
+	defer func() {
+		s.metrics.HandleDuration(time.Since(start))
+		span.End()
+	}()
+
+	s.handler.Handle(packet, s)
+}
+
+// idle reports whether the session has neither running handlers nor
+// pending requests waiting for response.
+func (s *Session) idle() bool {
+	return atomic.LoadInt32(&s.handling) == 0 && s.PendingRequests() == 0
+}
+
 // Id returns current session id
 func (s *Session) Id() int64 {
 	return s.id
 }
 
+// Logger returns the logger of session, which includes session id
+// and remote address in each output.
+func (s *Session) Logger() Logger {
+	return s.logger
+}
+
+// SetTap sets Tap of the session, nil means disable it.
+// ErrNotImplement is returned if the Conn of session doesn't support Tap.
+func (s *Session) SetTap(t Tap) error {
+	ts, ok := s.c.(tapSetter)
+	if !ok {
+		return ErrNotImplement
+	}
+	ts.setTap(t, s.id)
+	return nil
+}
+
+// Done returns a channel which is closed when the session closed.
+func (s *Session) Done() <-chan struct{} {
+	return s.closed
+}
+
+// CreatedAt returns the time when session created.
+func (s *Session) CreatedAt() time.Time {
+	return s.createdAt
+}
+
+// LastPacketTime returns the time when last packet received.
+func (s *Session) LastPacketTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.lastPackTs))
+}
+
+// BytesReceived returns the number of bytes read from connection,
+// -1 if the Conn doesn't count bytes.
+func (s *Session) BytesReceived() int64 {
+	if bc, ok := s.c.(byteCounter); ok {
+		return bc.bytesReceived()
+	}
+	return -1
+}
+
+// BytesSent returns the number of bytes written to connection,
+// -1 if the Conn doesn't count bytes.
+func (s *Session) BytesSent() int64 {
+	if bc, ok := s.c.(byteCounter); ok {
+		return bc.bytesSent()
+	}
+	return -1
+}
+
 func (s *Session) User() User {
 	return s.user
 }
@@ -113,14 +245,72 @@ func (s *Session) Get(key string) (interface{}, bool) {
 	return val, ok
 }
 
+// Data returns a copy of all user defined data set by Set.
+func (s *Session) Data() map[string]interface{} {
+	s.dataLock.RLock()
+	defer s.dataLock.RUnlock()
+
+	data := make(map[string]interface{}, len(s.data))
+	for k, v := range s.data {
+		data[k] = v
+	}
+	return data
+}
+
 func (s *Session) close() error {
 	select {
 	case <-s.closed:
 		return nil
 	default:
 	}
+	// the writer goroutine of send queue flushes queued packets in limited
+	// time and closes the connection, so closing doesn't wait a stalled peer.
+	if wd, ok := s.c.(writeDeadliner); ok {
+		wd.SetWriteDeadline(time.Now().Add(sendQueueFlushTimeout))
+	}
 	close(s.closed)
-	return s.c.Close()
+	s.failRequests()
+	return nil
+}
+
+// writeDeadliner is implemented by Conn which supports write deadline, e.g. net.Conn.
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// pendingRequest is a request waiting for response.
+type pendingRequest struct {
+	ch   chan Packet
+	span Span
+}
+
+// takeRequest finds and removes the pending request which packet responds to.
+func (s *Session) takeRequest(packet Packet) (*pendingRequest, bool) {
+	if !s.correlator.IsResponse(packet) {
+		return nil, false
+	}
+	key := s.correlator.ResponseKey(packet)
+
+	s.reqLock.Lock()
+	defer s.reqLock.Unlock()
+
+	req, ok := s.requests[key]
+	delete(s.requests, key)
+	return req, ok
+}
+
+// failRequests closes channels of all pending requests,
+// waiters will get ErrSessionClosed.
+func (s *Session) failRequests() {
+	s.reqLock.Lock()
+	defer s.reqLock.Unlock()
+
+	for key, req := range s.requests {
+		close(req.ch)
+		req.span.SetError(ErrSessionClosed)
+		req.span.End()
+		delete(s.requests, key)
+	}
 }
 
 func (s *Session) Close() error {
@@ -134,13 +324,73 @@ func (s *Session) SendPacket(p Packet) error {
 	return s.c.SendPacket(p)
 }
 
+// SendPacketAsync puts the packet into the send queue and returns immediately,
+// the packet will be written by a background goroutine. When the queue is full,
+// the behaviour depends on the OverflowPolicy of the session.
+// Packets queued before the session closed are flushed before the connection closed.
+// Note that packets sent by SendPacketAsync and SendPacket may be out of order.
+func (s *Session) SendPacketAsync(p Packet) error {
+	return s.sendQueue.push(p)
+}
+
+// TrySendPacketAsync is like SendPacketAsync but never blocks. If the queue is full
+// and the OverflowPolicy is OverflowBlock, the packet is discarded and ErrSendQueueFull returned.
+// It's useful for fan-out, so that a slow session doesn't block others.
+func (s *Session) TrySendPacketAsync(p Packet) error {
+	return s.sendQueue.tryPush(p)
+}
+
+// SendRequest sends the packet and returns a channel which receives the response,
+// which is matched by the Correlator of the session. The channel will be closed without response
+// if the session closed.
 func (s *Session) SendRequest(p Packet) (<-chan Packet, error) {
+	return s.sendRequest(context.Background(), p)
+}
+
+// SendRequestContext sends the packet and waits the response until ctx done.
+// ErrSessionClosed is returned if the session closed before response received.
+func (s *Session) SendRequestContext(ctx context.Context, p Packet) (Packet, error) {
+	ch, err := s.sendRequest(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, ErrSessionClosed
+		}
+		return resp, nil
+	case <-ctx.Done():
+		s.removeRequest(s.correlator.RequestKey(p), ch, ctx.Err())
+		return nil, ctx.Err()
+	}
+}
+
+func (s *Session) sendRequest(ctx context.Context, p Packet) (chan Packet, error) {
+	ctx, span := s.tracer.Start(ctx, spanSendRequest, time.Now(), "sessionId", s.id, "packetId", p.Id())
+	if tc, ok := p.(TraceCarrier); ok {
+		if tp := s.tracer.Inject(ctx); tp != "" {
+			p = tc.WithTraceParent(tp)
+		}
+	}
+
 	ch := make(chan Packet, 1)
 	s.reqLock.Lock()
-	s.requests[p.Id()] = ch
+	select {
+	case <-s.closed:
+		s.reqLock.Unlock()
+		span.SetError(ErrSessionClosed)
+		span.End()
+		return nil, ErrSessionClosed
+	default:
+	}
+	key := s.correlator.RequestKey(p)
+	s.requests[key] = &pendingRequest{ch: ch, span: span}
 	s.reqLock.Unlock()
 
 	if err := s.SendPacket(p); err != nil {
+		s.removeRequest(key, ch, err)
 		return nil, err
 	}
 
@@ -148,23 +398,31 @@ func (s *Session) SendRequest(p Packet) (<-chan Packet, error) {
 }
 
 func (s *Session) SendRequestTimeout(p Packet, timeout time.Duration) (Packet, error) {
-	ch := make(chan Packet, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return s.SendRequestContext(ctx, p)
+}
+
+// removeRequest removes the pending request if it's still waiting by ch,
+// err is the reason of removing.
+func (s *Session) removeRequest(key int64, ch chan Packet, err error) {
 	s.reqLock.Lock()
-	s.requests[p.Id()] = ch
-	s.reqLock.Unlock()
+	defer s.reqLock.Unlock()
 
-	if err := s.SendPacket(p); err != nil {
-		return nil, err
+	if req, ok := s.requests[key]; ok && req.ch == ch {
+		req.span.SetError(err)
+		req.span.End()
+		delete(s.requests, key)
 	}
+}
 
-	timer := time.NewTimer(timeout)
-	select {
-	case p := <-ch:
-		timer.Stop()
-		return p, nil
-	case <-timer.C:
-		return nil, context.DeadlineExceeded
-	}
+// PendingRequests returns the number of requests waiting for response.
+func (s *Session) PendingRequests() int {
+	s.reqLock.RLock()
+	defer s.reqLock.RUnlock()
+
+	return len(s.requests)
 }
 
 func (s *Session) LocalAddr() net.Addr {
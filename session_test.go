@@ -0,0 +1,155 @@
+package sockit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func requestSession(t *testing.T, handler Handler, c *fakeConn) *Session {
+	t.Helper()
+	if handler == nil {
+		handler = HandlerFunc(func(Packet, *Session) {})
+	}
+	mgr := NewManager(handler, nil)
+	t.Cleanup(func() { mgr.Close() })
+
+	s, err := mgr.StoreConn(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSendRequestContext(t *testing.T) {
+	c := newFakeConn()
+	c.writing = make(chan Packet, 1)
+	s := requestSession(t, nil, c)
+
+	go func() {
+		<-c.writing
+		c.in <- testPacket{ID: 7, Resp: true, Data: "pong"}
+	}()
+	resp, err := s.SendRequestContext(context.Background(), testPacket{ID: 7, Data: "ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := resp.(testPacket); p.Data != "pong" {
+		t.Fatalf("response %+v", p)
+	}
+	if n := s.PendingRequests(); n != 0 {
+		t.Fatalf("%d pending requests after response", n)
+	}
+}
+
+func TestSendRequestContextCanceled(t *testing.T) {
+	c := newFakeConn()
+	c.writing = make(chan Packet, 1)
+	s := requestSession(t, nil, c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-c.writing
+		cancel()
+	}()
+	if _, err := s.SendRequestContext(ctx, testPacket{ID: 1}); err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if n := s.PendingRequests(); n != 0 {
+		t.Fatalf("%d pending requests after canceled", n)
+	}
+}
+
+func TestSendRequestTimeout(t *testing.T) {
+	handled := make(chan Packet, 1)
+	c := newFakeConn()
+	s := requestSession(t, chanHandler(handled), c)
+
+	if _, err := s.SendRequestTimeout(testPacket{ID: 1}, 50*time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if n := s.PendingRequests(); n != 0 {
+		t.Fatalf("%d pending requests after timeout", n)
+	}
+
+	// a late response is not swallowed
+	c.in <- testPacket{ID: 1, Resp: true}
+	if p := recvPacket(t, handled); p.Id() != 1 {
+		t.Fatalf("handled packet %d", p.Id())
+	}
+}
+
+func TestSendRequestSendError(t *testing.T) {
+	errBroken := errors.New("broken pipe")
+	c := newFakeConn()
+	c.sendErr = errBroken
+	s := requestSession(t, nil, c)
+
+	if _, err := s.SendRequestContext(context.Background(), testPacket{ID: 1}); err != errBroken {
+		t.Fatalf("err = %v, want %v", err, errBroken)
+	}
+	if n := s.PendingRequests(); n != 0 {
+		t.Fatalf("%d pending requests after send error", n)
+	}
+}
+
+func TestSendRequestSessionClosed(t *testing.T) {
+	c := newFakeConn()
+	s := requestSession(t, nil, c)
+
+	ch, err := s.SendRequest(testPacket{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.SendRequestContext(context.Background(), testPacket{ID: 2})
+		done <- err
+	}()
+	waitFor(t, "pending requests", func() bool { return s.PendingRequests() == 2 })
+
+	s.Close()
+	if _, ok := <-ch; ok {
+		t.Fatal("response received from closed session")
+	}
+	select {
+	case err := <-done:
+		if err != ErrSessionClosed {
+			t.Fatalf("err = %v, want ErrSessionClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending request not failed")
+	}
+	if n := s.PendingRequests(); n != 0 {
+		t.Fatalf("%d pending requests after closed", n)
+	}
+	if _, err := s.SendRequest(testPacket{ID: 3}); err != ErrSessionClosed {
+		t.Fatalf("err = %v, want ErrSessionClosed", err)
+	}
+}
+
+func TestUnsolicitedPacketNotSwallowed(t *testing.T) {
+	handled := make(chan Packet, 1)
+	c := newFakeConn()
+	s := requestSession(t, chanHandler(handled), c)
+
+	ch, err := s.SendRequest(testPacket{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a packet reusing the id of pending request without response marker
+	c.in <- testPacket{ID: 1, Data: "push"}
+	if p := recvPacket(t, handled).(testPacket); p.Data != "push" {
+		t.Fatalf("handled %+v", p)
+	}
+	if n := s.PendingRequests(); n != 1 {
+		t.Fatalf("%d pending requests, want 1", n)
+	}
+
+	c.in <- testPacket{ID: 1, Resp: true, Data: "resp"}
+	if p := recvPacket(t, ch).(testPacket); p.Data != "resp" {
+		t.Fatalf("response %+v", p)
+	}
+}
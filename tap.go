@@ -0,0 +1,178 @@
+package sockit
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"reflect"
+	"time"
+)
+
+// Direction is the direction of a packet.
+type Direction uint8
+
+const (
+	// DirectionIn means the packet is read from connection.
+	DirectionIn Direction = iota
+
+	// DirectionOut means the packet is written to connection.
+	DirectionOut
+)
+
+func (d Direction) String() string {
+	if d == DirectionOut {
+		return "out"
+	}
+	return "in"
+}
+
+// TapEvent is a packet read from or written to connection.
+type TapEvent struct {
+	SessionId  int64
+	RemoteAddr net.Addr
+	Direction  Direction
+	Time       time.Time
+
+	// Frame is the raw bytes of the packet, it's only valid during the call of Tap.
+	Frame []byte
+
+	Packet Packet
+}
+
+// Tap observes packets of connections. It's called synchronously
+// in reading and writing, so it should return quickly.
+type Tap interface {
+	Tap(e *TapEvent)
+}
+
+// TapFunc is an adapter to allow the use of ordinary functions as Tap.
+type TapFunc func(e *TapEvent)
+
+func (f TapFunc) Tap(e *TapEvent) { f(e) }
+
+// tapSetter is implemented by Conn which supports Tap.
+type tapSetter interface {
+	setTap(t Tap, sessionId int64)
+}
+
+// tapProvider is implemented by ConnManager which configures Tap of sessions.
+type tapProvider interface {
+	tap() Tap
+}
+
+// tapHolder is stored in atomic.Value, since atomic.Value
+// can not store nil or values of different types.
+type tapHolder struct {
+	tap       Tap
+	sessionId int64
+}
+
+// HexdumpTap logs every packet with its frame in hexdump format.
+type HexdumpTap struct {
+	// Logger is used to output, default is DefaultLogger.
+	Logger Logger
+}
+
+func (t HexdumpTap) Tap(e *TapEvent) {
+	logger := t.Logger
+	if logger == nil {
+		logger = DefaultLogger
+	}
+	logger.Info(fmt.Sprintf("%s packet %T\n%s", e.Direction, e.Packet, hex.Dump(e.Frame)),
+		"sessionId", e.SessionId, "remoteAddr", e.RemoteAddr.String(), "packetId", e.Packet.Id())
+}
+
+// TapFilter reports whether the event should be passed to Tap.
+type TapFilter func(e *TapEvent) bool
+
+// FilterTap returns a Tap which only passes events accepted by filter to t.
+func FilterTap(t Tap, filter TapFilter) Tap {
+	return TapFunc(func(e *TapEvent) {
+		if filter(e) {
+			t.Tap(e)
+		}
+	})
+}
+
+// SessionFilter accepts events of given sessions.
+func SessionFilter(ids ...int64) TapFilter {
+	set := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return func(e *TapEvent) bool {
+		return set[e.SessionId]
+	}
+}
+
+// PacketTypeFilter accepts events whose packet has the same Go type as one of samples,
+// e.g. PacketTypeFilter(codec.TLVPacket{}). Use ProtocolTypeFilter to filter
+// by the type field of protocol.
+func PacketTypeFilter(samples ...Packet) TapFilter {
+	set := make(map[reflect.Type]bool, len(samples))
+	for _, p := range samples {
+		set[reflect.TypeOf(p)] = true
+	}
+	return func(e *TapEvent) bool {
+		return set[reflect.TypeOf(e.Packet)]
+	}
+}
+
+// PacketTyper is implemented by packets which have a type field in protocol,
+// e.g. codec.TLVPacket and codec.ProtobufPacket.
+type PacketTyper interface {
+	PacketType() int32
+}
+
+// ProtocolTypeFilter accepts events whose packet implements PacketTyper
+// and has one of types.
+func ProtocolTypeFilter(types ...int32) TapFilter {
+	set := make(map[int32]bool, len(types))
+	for _, typ := range types {
+		set[typ] = true
+	}
+	return func(e *TapEvent) bool {
+		p, ok := e.Packet.(PacketTyper)
+		return ok && set[p.PacketType()]
+	}
+}
+
+// PacketFilter accepts events whose packet satisfies fn.
+func PacketFilter(fn func(p Packet) bool) TapFilter {
+	return func(e *TapEvent) bool {
+		return fn(e.Packet)
+	}
+}
+
+// teeReader records bytes consumed from the buffered reader.
+type teeReader struct {
+	br  *bufio.Reader
+	buf []byte
+}
+
+var _ BufferedReader = (*teeReader)(nil)
+
+func (r *teeReader) Read(p []byte) (int, error) {
+	n, err := r.br.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	return n, err
+}
+
+func (r *teeReader) ReadByte() (byte, error) {
+	b, err := r.br.ReadByte()
+	if err == nil {
+		r.buf = append(r.buf, b)
+	}
+	return b, err
+}
+
+func (r *teeReader) Peek(n int) ([]byte, error) {
+	return r.br.Peek(n)
+}
+
+func (r *teeReader) ReadSlice(delim byte) ([]byte, error) {
+	line, err := r.br.ReadSlice(delim)
+	r.buf = append(r.buf, line...)
+	return line, err
+}
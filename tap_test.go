@@ -0,0 +1,90 @@
+package sockit
+
+import (
+	"bytes"
+	"testing"
+)
+
+type otherPacket struct{ testPacket }
+
+func TestTapFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter TapFilter
+		event  TapEvent
+		want   bool
+	}{
+		{"session", SessionFilter(1, 2), TapEvent{SessionId: 2, Packet: testPacket{}}, true},
+		{"other session", SessionFilter(1, 2), TapEvent{SessionId: 3, Packet: testPacket{}}, false},
+		{"go type", PacketTypeFilter(testPacket{}), TapEvent{Packet: testPacket{Type: 5}}, true},
+		{"other go type", PacketTypeFilter(testPacket{}), TapEvent{Packet: otherPacket{}}, false},
+		{"protocol type", ProtocolTypeFilter(5, 6), TapEvent{Packet: testPacket{Type: 6}}, true},
+		{"other protocol type", ProtocolTypeFilter(5, 6), TapEvent{Packet: testPacket{Type: 7}}, false},
+		{"protocol type of embedded", ProtocolTypeFilter(5), TapEvent{Packet: otherPacket{testPacket{Type: 5}}}, true},
+		{"predicate", PacketFilter(func(p Packet) bool { return p.Id() > 10 }), TapEvent{Packet: testPacket{ID: 11}}, true},
+		{"predicate rejected", PacketFilter(func(p Packet) bool { return p.Id() > 10 }), TapEvent{Packet: testPacket{ID: 10}}, false},
+	}
+	for _, tt := range tests {
+		var tapped bool
+		tap := FilterTap(TapFunc(func(e *TapEvent) { tapped = true }), tt.filter)
+		tap.Tap(&tt.event)
+		if tapped != tt.want {
+			t.Errorf("%s: tapped = %v, want %v", tt.name, tapped, tt.want)
+		}
+	}
+}
+
+func TestTapFrames(t *testing.T) {
+	events := make(chan TapEvent, 8)
+	tap := FilterTap(TapFunc(func(e *TapEvent) {
+		ev := *e
+		ev.Frame = append([]byte(nil), e.Frame...)
+		events <- ev
+	}), ProtocolTypeFilter(1))
+
+	handled := make(chan Packet, 4)
+	mgr := NewManager(HandlerFunc(func(p Packet, s *Session) {
+		s.SendPacket(p.(testPacket))
+		handled <- p
+	}), &NewManagerOptions{Tap: tap})
+	_, addr := startServer(t, mgr)
+
+	cli := NewClient(testCodec{}, HandlerFunc(func(Packet, *Session) {}), nil)
+	defer cli.Close()
+	s, err := cli.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := testPacket{ID: 1, Type: 1, Data: "hello"}
+	if err := s.SendPacket(p); err != nil {
+		t.Fatal(err)
+	}
+	recvPacket(t, handled)
+
+	var frame bytes.Buffer
+	testCodec{}.Write(&frame, p)
+	for _, dir := range []Direction{DirectionIn, DirectionOut} {
+		select {
+		case e := <-events:
+			if e.Direction != dir || !bytes.Equal(e.Frame, frame.Bytes()) || e.Packet.(testPacket) != p {
+				t.Fatalf("event %s %x %+v, want %s %x", e.Direction, e.Frame, e.Packet, dir, frame.Bytes())
+			}
+		default:
+			t.Fatalf("no %s event", dir)
+		}
+	}
+
+	// filtered out
+	s.SendPacket(testPacket{ID: 2, Type: 2})
+	recvPacket(t, handled)
+
+	// disabled at runtime
+	mgr.SetTap(nil)
+	s.SendPacket(testPacket{ID: 3, Type: 1})
+	recvPacket(t, handled)
+
+	if n := len(events); n != 0 {
+		t.Fatalf("%d events tapped, want 0", n)
+	}
+}
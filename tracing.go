@@ -0,0 +1,75 @@
+package sockit
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer starts spans for sending, decoding and handling packets.
+// It's designed to be easily implemented with OpenTelemetry.
+type Tracer interface {
+	// Start starts a span as child of the span in ctx, start is the start time of the span
+	// and attrs are alternating keys and values.
+	Start(ctx context.Context, name string, start time.Time, attrs ...interface{}) (context.Context, Span)
+
+	// Inject returns the trace context in ctx in text form, such as W3C traceparent.
+	Inject(ctx context.Context) string
+
+	// Extract returns a context which carries the remote trace context parsed from tp.
+	Extract(ctx context.Context, tp string) context.Context
+}
+
+// Span is a traced operation.
+type Span interface {
+	// SetError records the operation failed with err.
+	SetError(err error)
+
+	End()
+}
+
+// TraceCarrier is implemented by packets which carry trace context.
+type TraceCarrier interface {
+	// TraceParent returns the trace context carried by packet, empty if none.
+	TraceParent() string
+
+	// WithTraceParent returns a copy of packet which carries tp.
+	WithTraceParent(tp string) Packet
+}
+
+const (
+	spanSendRequest = "sockit.SendRequest"
+	spanDecode      = "sockit.Decode"
+	spanHandle      = "sockit.Handle"
+)
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string, start time.Time, attrs ...interface{}) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+func (nopTracer) Inject(ctx context.Context) string                      { return "" }
+func (nopTracer) Extract(ctx context.Context, tp string) context.Context { return ctx }
+
+type nopSpan struct{}
+
+func (nopSpan) SetError(err error) {}
+func (nopSpan) End()               {}
+
+// extractTraceContext returns context carrying trace context of packet.
+func extractTraceContext(tracer Tracer, p Packet) context.Context {
+	ctx := context.Background()
+	if tc, ok := p.(TraceCarrier); ok && tc.TraceParent() != "" {
+		ctx = tracer.Extract(ctx, tc.TraceParent())
+	}
+	return ctx
+}
+
+// tracerProvider is implemented by ConnManager which traces sessions.
+type tracerProvider interface {
+	tracer() Tracer
+}
+
+// tracerSetter is implemented by Conn which traces decoding.
+type tracerSetter interface {
+	setTracer(t Tracer)
+}
@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chenqinghe/sockit"
+)
+
+// SpanData is a finished span recorded by MemoryTracer.
+type SpanData struct {
+	Name         string
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	Err          error
+}
+
+// MemoryTracer is a sockit.Tracer which records finished spans in memory,
+// trace context is propagated in W3C traceparent format. It's mainly used in tests.
+type MemoryTracer struct {
+	mu    *sync.Mutex
+	spans []SpanData
+}
+
+var _ sockit.Tracer = (*MemoryTracer)(nil)
+
+func NewMemoryTracer() *MemoryTracer {
+	return &MemoryTracer{mu: &sync.Mutex{}}
+}
+
+type spanContext struct {
+	traceID string
+	spanID  string
+}
+
+type ctxKey struct{}
+
+func (t *MemoryTracer) Start(ctx context.Context, name string, start time.Time, attrs ...interface{}) (context.Context, sockit.Span) {
+	span := &memorySpan{
+		tracer: t,
+		data: SpanData{
+			Name:       name,
+			SpanID:     randomHex(8),
+			Start:      start,
+			Attributes: make(map[string]interface{}, len(attrs)/2),
+		},
+	}
+	if parent, ok := ctx.Value(ctxKey{}).(spanContext); ok {
+		span.data.TraceID = parent.traceID
+		span.data.ParentSpanID = parent.spanID
+	} else {
+		span.data.TraceID = randomHex(16)
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		span.data.Attributes[fmt.Sprint(attrs[i])] = attrs[i+1]
+	}
+
+	ctx = context.WithValue(ctx, ctxKey{}, spanContext{traceID: span.data.TraceID, spanID: span.data.SpanID})
+	return ctx, span
+}
+
+func (t *MemoryTracer) Inject(ctx context.Context) string {
+	sc, ok := ctx.Value(ctxKey{}).(spanContext)
+	if !ok {
+		return ""
+	}
+	return "00-" + sc.traceID + "-" + sc.spanID + "-01"
+}
+
+func (t *MemoryTracer) Extract(ctx context.Context, tp string) context.Context {
+	parts := strings.Split(tp, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKey{}, spanContext{traceID: parts[1], spanID: parts[2]})
+}
+
+// Spans returns all finished spans in the order they ended.
+func (t *MemoryTracer) Spans() []SpanData {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	spans := make([]SpanData, len(t.spans))
+	copy(spans, t.spans)
+	return spans
+}
+
+// Reset clears recorded spans.
+func (t *MemoryTracer) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.spans = nil
+}
+
+type memorySpan struct {
+	tracer *MemoryTracer
+	once   sync.Once
+	data   SpanData
+}
+
+// SetError records err, it takes no effect after the span ended.
+func (s *memorySpan) SetError(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+
+	s.data.Err = err
+}
+
+func (s *memorySpan) End() {
+	s.once.Do(func() {
+		s.tracer.mu.Lock()
+		defer s.tracer.mu.Unlock()
+
+		s.data.End = time.Now()
+		s.tracer.spans = append(s.tracer.spans, s.data)
+	})
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,156 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenqinghe/sockit"
+	"github.com/chenqinghe/sockit/codec"
+	"github.com/chenqinghe/sockit/rpc"
+)
+
+func findSpan(spans []SpanData, name string) (SpanData, bool) {
+	for _, s := range spans {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return SpanData{}, false
+}
+
+func waitSpan(t *testing.T, tracer *MemoryTracer, name string) SpanData {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if s, ok := findSpan(tracer.Spans(), name); ok {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("span %s not recorded", name)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	srvTracer, cliTracer := NewMemoryTracer(), NewMemoryTracer()
+
+	handler := rpc.NewDispatchHandler()
+	handler.Register(1, func(pkt *rpc.Packet, s *rpc.Session) {
+		s.Writer.WriteString("pong")
+	})
+	mgr := sockit.NewManager(handler, &sockit.NewManagerOptions{
+		Tracer: srvTracer,
+		Logger: sockit.NopLogger{},
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := sockit.NewServer(mgr, codec.TLVCodec{Version: codec.TLVVersion2})
+	go srv.Serve(l)
+	defer srv.Close()
+
+	cli := sockit.NewClient(codec.TLVCodec{Version: codec.TLVVersion2}, sockit.HandlerFunc(func(sockit.Packet, *sockit.Session) {}),
+		&sockit.NewClientOptions{
+			Correlator: codec.TLVCorrelator{},
+			Tracer:     cliTracer,
+			Logger:     sockit.NopLogger{},
+		})
+	defer cli.Close()
+	s, err := cli.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := s.SendRequestTimeout(codec.TLVPacket{
+		PacketHead: codec.PacketHead{Type: 1, ID: 42},
+		Data:       []byte("ping"),
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := resp.(codec.TLVPacket).Data; string(data) != "pong" {
+		t.Fatalf("response %q", data)
+	}
+
+	send := waitSpan(t, cliTracer, "sockit.SendRequest")
+	if send.Err != nil {
+		t.Fatalf("send span error: %v", send.Err)
+	}
+	for _, name := range []string{"sockit.Decode", "sockit.Handle"} {
+		span := waitSpan(t, srvTracer, name)
+		if span.TraceID != send.TraceID || span.ParentSpanID != send.SpanID {
+			t.Errorf("%s span %s/%s, want child of %s/%s", name, span.TraceID, span.ParentSpanID, send.TraceID, send.SpanID)
+		}
+		if id := span.Attributes["packetId"]; id != int64(42) {
+			t.Errorf("%s span packetId = %v", name, id)
+		}
+	}
+}
+
+func TestMemoryTracer(t *testing.T) {
+	tracer := NewMemoryTracer()
+
+	ctx, parent := tracer.Start(context.Background(), "parent", time.Now(), "key", "value", "odd")
+	tp := tracer.Inject(ctx)
+	if tp == "" {
+		t.Fatal("no trace context injected")
+	}
+
+	// the child is started from the trace context propagated
+	_, child := tracer.Start(tracer.Extract(context.Background(), tp), "child", time.Now())
+	errFailed := errors.New("failed")
+	child.SetError(errFailed)
+	child.End()
+	parent.End()
+	parent.End()
+
+	spans := tracer.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans recorded, want 2", len(spans))
+	}
+	c, p := spans[0], spans[1]
+	if c.Name != "child" || p.Name != "parent" {
+		t.Fatalf("spans %s, %s", c.Name, p.Name)
+	}
+	if c.TraceID != p.TraceID || c.ParentSpanID != p.SpanID || p.ParentSpanID != "" {
+		t.Errorf("child %s/%s, parent %s/%s", c.TraceID, c.ParentSpanID, p.TraceID, p.SpanID)
+	}
+	if c.Err != errFailed || p.Err != nil {
+		t.Errorf("errors %v, %v", c.Err, p.Err)
+	}
+	if len(p.Attributes) != 1 || p.Attributes["key"] != "value" {
+		t.Errorf("attributes %v", p.Attributes)
+	}
+
+	tracer.Reset()
+	if n := len(tracer.Spans()); n != 0 {
+		t.Fatalf("%d spans after reset", n)
+	}
+}
+
+func TestMemorySpanConcurrent(t *testing.T) {
+	tracer := NewMemoryTracer()
+	_, span := tracer.Start(context.Background(), "span", time.Now())
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		span.SetError(errors.New("failed"))
+	}()
+	go func() {
+		defer wg.Done()
+		span.End()
+	}()
+	wg.Wait()
+
+	if n := len(tracer.Spans()); n != 1 {
+		t.Fatalf("%d spans recorded, want 1", n)
+	}
+}
@@ -2,7 +2,6 @@ package sockit
 
 import (
 	"errors"
-	"fmt"
 	"net"
 	"net/http"
 	"sync/atomic"
@@ -23,6 +22,9 @@ type WsServer struct {
 	Manager   ConnManager
 	Upgrader  *websocket.Upgrader
 
+	// Logger is used to log server events. Default is DefaultLogger.
+	Logger Logger
+
 	server *http.Server
 }
 
@@ -44,7 +46,7 @@ func (ws *WsServer) ListenAndServe() error {
 	router.HandleFunc(ws.Path, func(w http.ResponseWriter, r *http.Request) {
 		conn, err := ws.Upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			fmt.Println("upgrade error:", err)
+			ws.logger().Error("upgrade error", "remoteAddr", r.RemoteAddr, "error", err.Error())
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -61,6 +63,13 @@ func (ws *WsServer) ListenAndServe() error {
 
 }
 
+func (ws *WsServer) logger() Logger {
+	if ws.Logger == nil {
+		return DefaultLogger
+	}
+	return ws.Logger
+}
+
 type WSConn struct {
 	*websocket.Conn
 }